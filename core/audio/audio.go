@@ -1,60 +1,139 @@
 package audio
 
 import (
+	"errors"
 	"time"
 
 	"gopkg.in/hraban/opus.v2"
 )
 
-const (
-	//FrameSize is the preset frame size for each audio data
-	FrameSize = 1920
-	//SampleRate is the preset sample rate for each audio data
-	SampleRate = 48000
+//SampleRate is the preset sample rate for each audio data
+const SampleRate = 48000
 
-	//FrameDuration is the duration of audio data in each frame
-	FrameDuration = time.Second * FrameSize / SampleRate
+//maximumFrameSize is the number of samples per channel in the longest Opus packet
+const maximumFrameSize = SampleRate * 120 / 1000
 
-	channels = 1
-)
+//maximumPacketSize is the recommended output buffer size of an Opus encoder
+const maximumPacketSize = 4000
+
+//Format refers to the codec parameters of the audio sent to peers
+type Format struct {
+	FrameDuration time.Duration
+	Channels      int
+	Application   opus.Application
+	//Bitrate and Complexity cap the settings chosen by a Controller
+	Bitrate    int
+	Complexity int
+}
+
+//DefaultFormat is used unless SetFormat is called
+var DefaultFormat = Format{
+	FrameDuration: time.Millisecond * 40,
+	Channels:      1,
+	Application:   opus.AppVoIP,
+	Bitrate:       64000,
+	Complexity:    10,
+}
+
+//MusicFormat sends full-band stereo, tuned for music rather than speech
+var MusicFormat = Format{
+	FrameDuration: time.Millisecond * 20,
+	Channels:      2,
+	Application:   opus.AppAudio,
+	Bitrate:       128000,
+	Complexity:    10,
+}
+
+//currentFormat is only changed by SetFormat
+var currentFormat = DefaultFormat
+
+//FrameSize returns the number of samples per channel in each audio frame
+func FrameSize() int {
+	return frameSize(currentFormat.FrameDuration)
+}
+
+//FrameDuration returns the duration of audio data in each frame
+func FrameDuration() time.Duration {
+	return currentFormat.FrameDuration
+}
+
+//Channels returns the number of interleaved channels in each audio frame
+func Channels() int {
+	return currentFormat.Channels
+}
 
-//CompressAudio uses opus codec to compress raw MONO audio data
-func CompressAudio(encoder *opus.Encoder, raw []float32) []byte {
-	data := make([]byte, 1024)
+//SetFormat changes the audio format, it must be called before any encoder, decoder or device is created
+func SetFormat(format Format) error {
+	switch format.FrameDuration {
+	case time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40, time.Millisecond * 60:
+	default:
+		return errors.New("Frame duration must be 10, 20, 40 or 60 ms")
+	}
+	if format.Channels != 1 && format.Channels != 2 {
+		return errors.New("Audio must be mono or stereo")
+	}
+	switch format.Application {
+	case opus.AppVoIP, opus.AppAudio, opus.AppRestrictedLowdelay:
+	default:
+		return errors.New("Unknown Opus application")
+	}
+	if format.Bitrate < 6000 || format.Bitrate > 510000 {
+		return errors.New("Bitrate must be between 6000 and 510000")
+	}
+	if format.Complexity < 0 || format.Complexity > 10 {
+		return errors.New("Complexity must be between 0 and 10")
+	}
+
+	currentFormat = format
+	return nil
+}
+
+//CurrentFormat returns the format set by SetFormat
+func CurrentFormat() Format {
+	return currentFormat
+}
+
+func frameSize(duration time.Duration) int {
+	return int(SampleRate * duration / time.Second)
+}
+
+//CompressAudio uses opus codec to compress raw interleaved audio data
+func CompressAudio(encoder *opus.Encoder, raw []float32) ([]byte, error) {
+	data := make([]byte, maximumPacketSize)
 	n, err := encoder.EncodeFloat32(raw, data)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return data[:n]
+	return data[:n], nil
 }
 
-//DecompressAudio uses opus codec to decompress opus data to raw MONO audio data
+//DecompressAudio uses opus codec to decompress opus data to raw interleaved audio data
 func DecompressAudio(decoder *opus.Decoder, data []byte) ([]float32, error) {
-	var frameSizeMs float32 = 60
-	frameSize := channels * frameSizeMs * SampleRate / 1000
-	pcm := make([]float32, int(frameSize))
+	pcm := make([]float32, maximumFrameSize*Channels())
 	n, err := decoder.DecodeFloat32(data, pcm)
 	if err != nil {
 		return nil, err
 	}
 
-	return pcm[:n], nil
+	return pcm[:n*Channels()], nil
 }
 
-//NewEncoder creates a new Opus encoder
-func NewEncoder() *opus.Encoder {
-	enc, err := opus.NewEncoder(SampleRate, channels, opus.AppVoIP)
-	if err != nil {
-		panic(err)
+//RecoverAudio reconstructs a lost frame of frameSize samples per channel
+//from the FEC data carried by the frame following it
+func RecoverAudio(decoder *opus.Decoder, next []byte, frameSize int) ([]float32, error) {
+	pcm := make([]float32, frameSize*Channels())
+	if err := decoder.DecodeFECFloat32(next, pcm); err != nil {
+		return nil, err
 	}
-	return enc
+	return pcm, nil
 }
 
-//NewDecoder creates a new Opus decoder
-func NewDecoder() *opus.Decoder {
-	dec, err := opus.NewDecoder(SampleRate, channels)
-	if err != nil {
-		panic(err)
-	}
-	return dec
+//NewEncoder creates a new Opus encoder
+func NewEncoder() (*opus.Encoder, error) {
+	return opus.NewEncoder(SampleRate, Channels(), currentFormat.Application)
+}
+
+//NewDecoder creates a new Opus decoder, streams with another channel count are converted to Channels
+func NewDecoder() (*opus.Decoder, error) {
+	return opus.NewDecoder(SampleRate, Channels())
 }
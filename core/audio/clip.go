@@ -0,0 +1,265 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+//Clip is a sound played into the call, such as an alert or a recording
+type Clip interface {
+	//Read fills pcm with interleaved samples of the current format, it returns io.EOF once the clip ended
+	Read(pcm []float32) (int, error)
+	Close() error
+}
+
+//OpenClip opens a WAV or Ogg Opus file as a Clip
+func OpenClip(path string) (Clip, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var clip Clip
+	switch string(magic) {
+	case "RIFF":
+		clip, err = newWAVClip(reader, file)
+	case "OggS":
+		clip, err = newOggClip(reader, file)
+	default:
+		err = errors.New("Only WAV and Ogg Opus files can be played")
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return clip, nil
+}
+
+type wavClip struct {
+	wav    *WAVReader
+	closer io.Closer
+	//resampler converts files that are not at SampleRate, nil if they are
+	resampler *Resampler
+	ended     bool
+	buffer    []float32
+	converted []float32
+	pending   []float32
+}
+
+func newWAVClip(reader io.Reader, closer io.Closer) (Clip, error) {
+	wav, err := NewWAVReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if wav.Channels != 1 && wav.Channels != 2 {
+		return nil, errors.New("WAV files must be mono or stereo")
+	}
+	clip := &wavClip{wav: wav, closer: closer}
+	if wav.SampleRate != SampleRate {
+		clip.resampler = NewResampler(wav.SampleRate, SampleRate, Channels())
+	}
+	return clip, nil
+}
+
+func (clip *wavClip) Read(pcm []float32) (int, error) {
+	for len(clip.pending) < len(pcm) && !clip.ended {
+		//Blocks of a frame duration at the rate of the file
+		frames := clip.wav.SampleRate * FrameSize() / SampleRate
+		if len(clip.buffer) < frames*clip.wav.Channels {
+			clip.buffer = make([]float32, frames*clip.wav.Channels)
+			clip.converted = make([]float32, frames*Channels())
+		}
+		n, err := clip.wav.Read(clip.buffer[:frames*clip.wav.Channels])
+		if err == io.EOF {
+			clip.ended = true
+		} else if err != nil {
+			return 0, err
+		}
+
+		converted := clip.converted[:convertChannels(clip.buffer[:n], clip.wav.Channels, clip.converted)]
+		if clip.resampler != nil {
+			if clip.ended {
+				//Silence pushes the end of the file through the filter
+				converted = make([]float32, clip.resampler.halfWidth*Channels())
+			}
+			converted = clip.resampler.Process(converted)
+		}
+		clip.pending = append(clip.pending, converted...)
+	}
+
+	n := copy(pcm, clip.pending)
+	clip.pending = clip.pending[n:]
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (clip *wavClip) Close() error {
+	return clip.closer.Close()
+}
+
+type oggClip struct {
+	ogg     *OggReader
+	decoder *opus.Decoder
+	closer  io.Closer
+	//preSkip is the number of samples per channel still to drop from the start of the stream
+	preSkip int
+	pending []float32
+}
+
+func newOggClip(reader io.Reader, closer io.Closer) (Clip, error) {
+	ogg := NewOggReader(reader)
+	head, err := ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, errors.New("Ogg file does not hold Opus audio")
+	}
+	if head[18] != 0 {
+		return nil, errors.New("Only mono and stereo Ogg Opus files can be played")
+	}
+	if _, err := ogg.ReadPacket(); err != nil {
+		return nil, err
+	}
+
+	decoder, err := NewDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return &oggClip{
+		ogg:     ogg,
+		decoder: decoder,
+		closer:  closer,
+		preSkip: int(binary.LittleEndian.Uint16(head[10:])),
+	}, nil
+}
+
+func (clip *oggClip) Read(pcm []float32) (int, error) {
+	for len(clip.pending) < len(pcm) {
+		packet, err := clip.ogg.ReadPacket()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		data, err := DecompressAudio(clip.decoder, packet)
+		if err != nil {
+			continue
+		}
+		if skip := clip.preSkip * Channels(); skip > 0 {
+			if skip > len(data) {
+				skip = len(data)
+			}
+			clip.preSkip -= skip / Channels()
+			data = data[skip:]
+		}
+		clip.pending = append(clip.pending, data...)
+	}
+
+	n := copy(pcm, clip.pending)
+	clip.pending = clip.pending[n:]
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (clip *oggClip) Close() error {
+	return clip.closer.Close()
+}
+
+//convertChannels copies interleaved samples of from channels into out with Channels channels,
+//returning the number of samples written
+func convertChannels(in []float32, from int, out []float32) int {
+	frames, channels := len(in)/from, Channels()
+	for i := 0; i < frames; i++ {
+		switch {
+		case from == channels:
+			copy(out[i*channels:(i+1)*channels], in[i*from:(i+1)*from])
+		case from == 1:
+			out[2*i], out[2*i+1] = in[i], in[i]
+		default:
+			out[i] = (in[2*i] + in[2*i+1]) / 2
+		}
+	}
+	return frames * channels
+}
+
+//Injector plays a Clip into captured frames, mixed with the microphone or in place of it
+type Injector struct {
+	mutex   sync.Mutex
+	clip    Clip
+	replace bool
+	buffer  []float32
+}
+
+//NewInjector creates an Injector playing nothing
+func NewInjector() *Injector {
+	return &Injector{}
+}
+
+//Play starts playing clip, stopping the one being played. The microphone is muted while it plays if replace is true
+func (injector *Injector) Play(clip Clip, replace bool) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	if injector.clip != nil {
+		injector.clip.Close()
+	}
+	injector.clip, injector.replace = clip, replace
+}
+
+//Stop stops playing the current clip
+func (injector *Injector) Stop() {
+	injector.Play(nil, false)
+}
+
+//Playing tells whether a clip is being played
+func (injector *Injector) Playing() bool {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	return injector.clip != nil
+}
+
+//Process adds the next frame of the clip to a captured frame, or replaces it
+func (injector *Injector) Process(pcm []float32) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	if injector.clip == nil {
+		return
+	}
+
+	if len(injector.buffer) < len(pcm) {
+		injector.buffer = make([]float32, len(pcm))
+	}
+	buffer := injector.buffer[:len(pcm)]
+	n, err := injector.clip.Read(buffer)
+	if err != nil {
+		injector.clip.Close()
+		injector.clip = nil
+	}
+	for i := n; i < len(buffer); i++ {
+		buffer[i] = 0
+	}
+
+	for i, v := range buffer {
+		if injector.replace {
+			pcm[i] = v
+		} else {
+			pcm[i] = softClip(pcm[i] + v)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+//Settings refers to the tunable parameters of an Opus encoder
+type Settings struct {
+	Bitrate    int
+	Complexity int
+	FEC        bool
+	PacketLoss int
+}
+
+//Feedback refers to the network conditions of a send path as seen by the receiver
+type Feedback struct {
+	Loss   float32
+	Jitter time.Duration
+	RTT    time.Duration
+}
+
+//levels is the ladder of settings walked by a Controller, from best quality to most robust
+var levels = []Settings{
+	{Bitrate: 64000, Complexity: 10, FEC: false, PacketLoss: 0},
+	{Bitrate: 48000, Complexity: 10, FEC: false, PacketLoss: 0},
+	{Bitrate: 32000, Complexity: 10, FEC: true, PacketLoss: 5},
+	{Bitrate: 24000, Complexity: 10, FEC: true, PacketLoss: 10},
+	{Bitrate: 16000, Complexity: 10, FEC: true, PacketLoss: 20},
+	{Bitrate: 12000, Complexity: 10, FEC: true, PacketLoss: 30},
+}
+
+const (
+	defaultLevel = 2
+
+	degradeLoss   = 0.05
+	degradeJitter = time.Millisecond * 60
+	degradeRTT    = time.Millisecond * 400
+	upgradeLoss   = 0.01
+
+	//upgradeFeedbacks is the number of consecutive good feedbacks needed to raise quality
+	upgradeFeedbacks = 3
+)
+
+//DefaultSettings is used before any feedback is received
+var DefaultSettings = levels[defaultLevel]
+
+//Apply configures an encoder with the settings
+func (settings Settings) Apply(encoder *opus.Encoder) error {
+	if err := encoder.SetBitrate(settings.Bitrate); err != nil {
+		return err
+	}
+	if err := encoder.SetComplexity(settings.Complexity); err != nil {
+		return err
+	}
+	if err := encoder.SetInBandFEC(settings.FEC); err != nil {
+		return err
+	}
+	return encoder.SetPacketLossPerc(settings.PacketLoss)
+}
+
+//Controller adapts encoder Settings of one send path to the feedback of its receiver
+type Controller struct {
+	mutex         sync.Mutex
+	level         int
+	goodFeedbacks int
+}
+
+//NewController creates a Controller starting at DefaultSettings
+func NewController() *Controller {
+	return &Controller{level: defaultLevel}
+}
+
+//Update moves one step down the ladder on bad feedback, and one step up after consistently good feedback
+func (controller *Controller) Update(feedback Feedback) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	bad := feedback.Loss > degradeLoss || feedback.Jitter > degradeJitter || feedback.RTT > degradeRTT
+	switch {
+	case bad:
+		controller.goodFeedbacks = 0
+		if controller.level < len(levels)-1 {
+			controller.level++
+		}
+	case feedback.Loss <= upgradeLoss:
+		controller.goodFeedbacks++
+		if controller.goodFeedbacks >= upgradeFeedbacks && controller.level > 0 {
+			controller.level--
+			controller.goodFeedbacks = 0
+		}
+	default:
+		controller.goodFeedbacks = 0
+	}
+}
+
+//Settings returns the current encoder settings, capped by the bitrate and complexity of the current Format.
+//The ladder is meant for mono, stereo gets twice the bitrate at each level
+func (controller *Controller) Settings() Settings {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	settings := levels[controller.level]
+	format := CurrentFormat()
+	settings.Bitrate *= format.Channels
+	if settings.Bitrate > format.Bitrate {
+		settings.Bitrate = format.Bitrate
+	}
+	if settings.Complexity > format.Complexity {
+		settings.Complexity = format.Complexity
+	}
+	return settings
+}
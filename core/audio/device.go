@@ -0,0 +1,58 @@
+package audio
+
+import (
+	"time"
+)
+
+//Device is a full duplex audio device exchanging frames of FrameSize interleaved samples per channel
+type Device interface {
+	//Read blocks until a captured frame is available and copies it into in
+	Read(in []float32) error
+	//Write queues a frame for playback
+	Write(out []float32) error
+	Close() error
+}
+
+type nullDevice struct{}
+
+//NewNullDevice creates a Device that captures silence and discards playback
+func NewNullDevice() Device {
+	return nullDevice{}
+}
+
+func (nullDevice) Read(in []float32) error {
+	for i := range in {
+		in[i] = 0
+	}
+	return nil
+}
+
+func (nullDevice) Write(out []float32) error {
+	return nil
+}
+
+func (nullDevice) Close() error {
+	return nil
+}
+
+type realtimeDevice struct {
+	Device
+	next time.Time
+}
+
+//Realtime paces the reads of a device that does not block, such as a null or file device,
+//to one frame every FrameDuration
+func Realtime(device Device) Device {
+	return &realtimeDevice{Device: device}
+}
+
+func (device *realtimeDevice) Read(in []float32) error {
+	now := time.Now()
+	if device.next.Before(now) {
+		device.next = now
+	} else {
+		time.Sleep(device.next.Sub(now))
+	}
+	device.next = device.next.Add(FrameDuration())
+	return device.Device.Read(in)
+}
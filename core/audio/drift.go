@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"math"
+)
+
+const (
+	//driftSmoothing is the weight of each measurement of the queue length in its running average
+	driftSmoothing = 0.01
+	//driftSettleFrames is the number of frames measured before the queue length to hold is chosen
+	driftSettleFrames = 100
+	//driftProportional is the change of playback speed per second of queue above or below the target,
+	//driftIntegral the change per second of that error accumulated over each second. Together they
+	//bring the queue back to its target in about a minute without overshooting
+	driftProportional = 0.05
+	driftIntegral     = driftProportional * driftProportional / 4
+	//maximumDriftCorrection bounds the change of playback speed, it is small enough not to be heard
+	maximumDriftCorrection = 0.005
+)
+
+//DriftCompensator keeps a receive queue at a steady length although the sound card of the sender
+//runs slightly faster or slower than ours, by playing received audio slightly faster or slower
+type DriftCompensator struct {
+	resampler *Resampler
+	//resampling is set once the speed first moved away from 1. Audio goes through the resampler from then on,
+	//as the speed is rarely exactly 1 again and leaving the resampler would drop the samples it holds
+	resampling bool
+	frames     int
+	level      float64
+	target     float64
+	//integral is the part of the correction that matches the clock drift once the queue is on target
+	integral float64
+}
+
+//NewDriftCompensator creates a DriftCompensator playing at normal speed
+func NewDriftCompensator() *DriftCompensator {
+	return &DriftCompensator{resampler: NewResampler(SampleRate, SampleRate, Channels())}
+}
+
+//Measure records the length of the queue, in samples per channel, and adapts the playback speed to it.
+//It should only be called while audio is being received, as the queue of a silent sender is empty
+func (compensator *DriftCompensator) Measure(queued int) {
+	compensator.frames++
+	if compensator.frames == 1 {
+		compensator.level = float64(queued)
+	} else {
+		compensator.level += (float64(queued) - compensator.level) * driftSmoothing
+	}
+
+	if compensator.frames < driftSettleFrames {
+		return
+	}
+	if compensator.frames == driftSettleFrames {
+		compensator.target = compensator.level
+	}
+	errorSeconds := (compensator.level - compensator.target) / SampleRate
+	compensator.integral += errorSeconds * FrameDuration().Seconds() * driftIntegral
+	compensator.integral = math.Max(-maximumDriftCorrection, math.Min(maximumDriftCorrection, compensator.integral))
+	correction := errorSeconds*driftProportional + compensator.integral
+	if correction > maximumDriftCorrection {
+		correction = maximumDriftCorrection
+	} else if correction < -maximumDriftCorrection {
+		correction = -maximumDriftCorrection
+	}
+	compensator.resampler.step = 1 + correction
+}
+
+//Speed returns the current playback speed, above 1 when the queue is drained faster than it fills
+func (compensator *DriftCompensator) Speed() float64 {
+	return compensator.resampler.step
+}
+
+//Process plays decoded audio at the current speed, the returned slice is only valid until the next call
+func (compensator *DriftCompensator) Process(pcm []float32) []float32 {
+	resampler := compensator.resampler
+	if !compensator.resampling {
+		if resampler.step == 1 {
+			//Filtering would only delay and dull the audio. The history of the resampler keeps the latest samples
+			//so that it can take over without a gap
+			history := resampler.history
+			if len(pcm) >= len(history) {
+				copy(history, pcm[len(pcm)-len(history):])
+			} else {
+				copy(history, history[len(pcm):])
+				copy(history[len(history)-len(pcm):], pcm)
+			}
+			return pcm
+		}
+		compensator.resampling = true
+	}
+	return resampler.Process(pcm)
+}
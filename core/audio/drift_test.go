@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDriftCompensatorBypass(t *testing.T) {
+	compensator := NewDriftCompensator()
+	speech := &tone{frequency: 440}
+	for f := 0; f < 10; f++ {
+		in := speech.frame(0.5)
+		out := compensator.Process(in)
+		if len(out) != len(in) {
+			t.Fatalf("Frame %d has %d samples at normal speed, want %d", f, len(out), len(in))
+		}
+		for i := range in {
+			if out[i] != in[i] {
+				t.Fatalf("Frame %d is changed at normal speed", f)
+			}
+		}
+	}
+}
+
+func TestDriftCompensatorTakeover(t *testing.T) {
+	compensator := NewDriftCompensator()
+	speech := &tone{frequency: 440}
+	for f := 0; f < 10; f++ {
+		compensator.Process(speech.frame(0.5))
+	}
+
+	//Once the speed changes, the output follows the tone from where it was, read faster, without a gap or a fade in
+	start := speech.phase
+	step := 1 + maximumDriftCorrection
+	compensator.resampler.step = step
+	var played []float32
+	for f := 0; f < 10; f++ {
+		played = append(played, compensator.Process(speech.frame(0.5))...)
+	}
+	for i := 0; i < len(played)/Channels(); i++ {
+		want := 0.5 * math.Sin(start+2*math.Pi*440*float64(i)*step/SampleRate)
+		if got := float64(played[i*Channels()]); math.Abs(got-want) > 0.01 {
+			t.Fatalf("Sample %d after the speed changed is %.3f, want %.3f", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package audio
+
+import (
+	"math"
+)
+
+const (
+	//echoTaps is the length of the adaptive filter, it covers the echo tail around the estimated delay
+	echoTaps = 512
+	//maximumEchoDelay is the largest playback to capture delay, in samples, the canceller can follow
+	maximumEchoDelay = SampleRate / 2
+	//echoStepSize is the NLMS adaptation rate
+	echoStepSize = 0.3
+
+	//envelopeBlock is the number of samples summarized in one point of the delay estimation envelopes
+	envelopeBlock = SampleRate / 1000
+	//envelopeLength is the number of envelope points correlated to estimate the delay
+	envelopeLength = 2 * SampleRate / envelopeBlock
+	//delayEstimationFrames is the number of frames between two delay estimations
+	delayEstimationFrames = 25
+	//minimumCorrelation is the normalized correlation a delay estimate needs to be trusted
+	minimumCorrelation = 0.5
+
+	//residualSuppression is the gain applied to what is left of a frame that was mostly echo
+	residualSuppression = 0.3
+)
+
+//EchoCanceller removes the echo of the playback signal from captured frames.
+//Each captured channel has its own echo path, so it is cancelled separately against the playback downmix
+type EchoCanceller struct {
+	channels []*echoChannel
+	//mono holds one deinterleaved channel of the frame being processed
+	mono []float32
+}
+
+//NewEchoCanceller creates an EchoCanceller for frames of Channels channels
+func NewEchoCanceller() *EchoCanceller {
+	canceller := &EchoCanceller{mono: make([]float32, FrameSize())}
+	for c := 0; c < Channels(); c++ {
+		canceller.channels = append(canceller.channels, newEchoChannel())
+	}
+	return canceller
+}
+
+//Playback feeds the frame that was just sent to the speaker
+func (canceller *EchoCanceller) Playback(out []float32) {
+	channels := len(canceller.channels)
+	mono := canceller.mono[:len(out)/channels]
+	for i := range mono {
+		var sum float32
+		for c := 0; c < channels; c++ {
+			sum += out[i*channels+c]
+		}
+		mono[i] = sum / float32(channels)
+	}
+	for _, channel := range canceller.channels {
+		channel.playback(mono)
+	}
+}
+
+//Process removes the echo from a captured frame in place
+func (canceller *EchoCanceller) Process(in []float32) {
+	channels := len(canceller.channels)
+	mono := canceller.mono[:len(in)/channels]
+	for c, channel := range canceller.channels {
+		for i := range mono {
+			mono[i] = in[i*channels+c]
+		}
+		channel.process(mono)
+		for i, v := range mono {
+			in[i*channels+c] = v
+		}
+	}
+}
+
+//echoChannel cancels the echo of one captured channel.
+//It estimates the bulk delay between playback and capture by correlating their envelopes,
+//then cancels the echo around that delay with an NLMS adaptive filter
+type echoChannel struct {
+	weights []float32
+	//reference holds the most recent played samples, the last one being the newest
+	reference []float32
+
+	delay  int
+	frames int
+
+	captureEnvelope   []float32
+	referenceEnvelope []float32
+}
+
+func newEchoChannel() *echoChannel {
+	return &echoChannel{
+		weights:           make([]float32, echoTaps),
+		reference:         make([]float32, maximumEchoDelay+echoTaps),
+		delay:             FrameSize(),
+		captureEnvelope:   make([]float32, envelopeLength),
+		referenceEnvelope: make([]float32, envelopeLength+maximumEchoDelay/envelopeBlock),
+	}
+}
+
+func (canceller *echoChannel) playback(out []float32) {
+	copy(canceller.reference, canceller.reference[len(out):])
+	copy(canceller.reference[len(canceller.reference)-len(out):], out)
+	appendEnvelope(canceller.referenceEnvelope, out)
+}
+
+func (canceller *echoChannel) process(in []float32) {
+	appendEnvelope(canceller.captureEnvelope, in)
+	canceller.frames++
+	if canceller.frames%delayEstimationFrames == 0 {
+		canceller.estimateDelay()
+	}
+
+	start := canceller.start()
+
+	//Double talk: the near end is louder than anything the echo could produce, so adapting would diverge
+	window := canceller.reference[len(canceller.reference)-start-echoTaps+1 : len(canceller.reference)-start+len(in)]
+	adapt := rms(in) < 2*rms(window) && rms(window) > 0
+
+	echoEnergy, errorEnergy := 0.0, 0.0
+	for i := range in {
+		x := canceller.reference[len(canceller.reference)+i-start-echoTaps+1 : len(canceller.reference)+i-start+1]
+
+		var estimate, power float32
+		for k, w := range canceller.weights {
+			v := x[echoTaps-1-k]
+			estimate += w * v
+			power += v * v
+		}
+		e := in[i] - estimate
+		echoEnergy += float64(estimate * estimate)
+		errorEnergy += float64(e * e)
+
+		if adapt {
+			step := echoStepSize * e / (power + 1e-6)
+			for k := range canceller.weights {
+				canceller.weights[k] += step * x[echoTaps-1-k]
+			}
+		}
+		in[i] = e
+	}
+
+	if adapt && echoEnergy > 4*errorEnergy {
+		for i := range in {
+			in[i] *= residualSuppression
+		}
+	}
+}
+
+//estimateDelay finds the lag that best aligns the capture envelope with the reference envelope
+func (canceller *echoChannel) estimateDelay() {
+	capture := canceller.captureEnvelope
+	reference := canceller.referenceEnvelope
+	maximumLag := (maximumEchoDelay - FrameSize()) / envelopeBlock
+
+	//Envelopes are always positive, their means are removed so that unrelated signals do not correlate
+	captureMean := mean(capture)
+	captureEnergy := 0.0
+	for _, c := range capture {
+		captureEnergy += (float64(c) - captureMean) * (float64(c) - captureMean)
+	}
+
+	bestLag, bestCorrelation := -1, minimumCorrelation
+	for lag := 0; lag <= maximumLag; lag++ {
+		//The capture envelope already holds the current frame while the reference stops one frame
+		//earlier, so a lag of 0 stands for a delay of one frame
+		window := reference[len(reference)-len(capture)-lag : len(reference)-lag]
+		windowMean := mean(window)
+		var product, windowEnergy float64
+		for j, c := range capture {
+			w := float64(window[j]) - windowMean
+			product += (float64(c) - captureMean) * w
+			windowEnergy += w * w
+		}
+		if norm := math.Sqrt(captureEnergy * windowEnergy); norm > 0 {
+			if correlation := product / norm; correlation > bestCorrelation {
+				bestLag, bestCorrelation = lag, correlation
+			}
+		}
+	}
+
+	if bestLag < 0 {
+		return
+	}
+	delay := FrameSize() + bestLag*envelopeBlock
+	if delay == canceller.delay {
+		return
+	}
+	//The estimate wanders by a block or so around the true delay, the filter is moved along
+	//rather than cleared so that the echo it learned is still cancelled
+	start := canceller.start()
+	canceller.delay = delay
+	shift := canceller.start() - start
+	weights := make([]float32, echoTaps)
+	for k := range weights {
+		if k+shift >= 0 && k+shift < echoTaps {
+			weights[k] = canceller.weights[k+shift]
+		}
+	}
+	canceller.weights = weights
+}
+
+//start returns how many samples before the captured one the filter starts. It starts a little before
+//the estimated delay to catch early reflections, but never reaches reference samples that have not been played yet
+func (canceller *echoChannel) start() int {
+	start := canceller.delay - echoTaps/4
+	if start < FrameSize() {
+		start = FrameSize()
+	}
+	return start
+}
+
+//appendEnvelope shifts the mean absolute value of each block of pcm into envelope
+func appendEnvelope(envelope []float32, pcm []float32) {
+	blocks := len(pcm) / envelopeBlock
+	copy(envelope, envelope[blocks:])
+	for b := 0; b < blocks; b++ {
+		var sum float32
+		for _, v := range pcm[b*envelopeBlock : (b+1)*envelopeBlock] {
+			sum += float32(math.Abs(float64(v)))
+		}
+		envelope[len(envelope)-blocks+b] = sum / envelopeBlock
+	}
+}
+
+func mean(values []float32) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += float64(v)
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,206 @@
+package audio
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//echoDelay is the delay, in samples, of the simulated room between the speaker and the microphone
+const echoDelay = 3000
+
+//echoRoom plays frames of noise and captures their echo, delayed and attenuated with a small reflection
+type echoRoom struct {
+	random *rand.Rand
+	played []float32
+	frame  int
+}
+
+func newEchoRoom() *echoRoom {
+	return &echoRoom{random: rand.New(rand.NewSource(1))}
+}
+
+//playback returns the next frame sent to the speaker, its loudness changing slowly like speech
+func (room *echoRoom) playback() []float32 {
+	out := make([]float32, FrameSize())
+	for i := range out {
+		loudness := 0.5 + 0.5*math.Sin(float64(room.frame*FrameSize()+i)/3000)
+		out[i] = float32(room.random.NormFloat64() * 0.1 * loudness)
+	}
+	return out
+}
+
+//capture returns the echo heard by the microphone while out is played, and records out as played
+func (room *echoRoom) capture(out []float32) []float32 {
+	in := make([]float32, FrameSize())
+	for i := range in {
+		n := room.frame*FrameSize() + i - echoDelay
+		if n >= 7 && n < len(room.played) {
+			in[i] = 0.6*room.played[n] + 0.2*room.played[n-7]
+		}
+	}
+	room.played = append(room.played, out...)
+	room.frame++
+	return in
+}
+
+func energy(pcm []float32) float64 {
+	sum := 0.0
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+	}
+	return sum
+}
+
+//interleave copies a mono frame to every channel
+func interleave(mono []float32) []float32 {
+	pcm := make([]float32, len(mono)*Channels())
+	for i, v := range mono {
+		for c := 0; c < Channels(); c++ {
+			pcm[i*Channels()+c] = v
+		}
+	}
+	return pcm
+}
+
+//writeEchoFixtures writes frames of the room to WAV files: the far end as sent to the speaker, and the near end
+//as captured by the microphone. near returns the voice of the near end added to a frame, or nil when it is silent
+func writeEchoFixtures(t testing.TB, frames int, near func(frame int) []float32) (farPath, nearPath string) {
+	directory := t.TempDir()
+	farPath, nearPath = filepath.Join(directory, "far.wav"), filepath.Join(directory, "near.wav")
+	far, farWriter := createWAV(t, farPath)
+	captured, nearWriter := createWAV(t, nearPath)
+
+	room := newEchoRoom()
+	for f := 0; f < frames; f++ {
+		out := room.playback()
+		in := room.capture(out)
+		if voice := near(f); voice != nil {
+			for i, v := range voice {
+				in[i] += v
+			}
+		}
+		if err := farWriter.Write(interleave(out)); err != nil {
+			t.Fatal(err)
+		}
+		if err := nearWriter.Write(interleave(in)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, closer := range []io.Closer{farWriter, far, nearWriter, captured} {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return farPath, nearPath
+}
+
+func createWAV(t testing.TB, path string) (*os.File, *WAVWriter) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewWAVWriter(file, SampleRate, Channels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, writer
+}
+
+func openWAV(t testing.TB, path string) *WAVReader {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		file.Close()
+	})
+	reader, err := NewWAVReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+//cancelWAV runs a new canceller over the near end of the fixtures, playing back their far end,
+//and returns the energy of each captured frame before and after cancellation
+func cancelWAV(t testing.TB, farPath, nearPath string) (captured, left []float64) {
+	far, near := openWAV(t, farPath), openWAV(t, nearPath)
+	canceller := NewEchoCanceller()
+	out := make([]float32, FrameSize()*Channels())
+	in := make([]float32, FrameSize()*Channels())
+	for {
+		if n, err := near.Read(in); n < len(in) || err != nil {
+			return captured, left
+		}
+		if n, err := far.Read(out); n < len(out) || err != nil {
+			t.Fatal("Far end is shorter than the near end")
+		}
+		captured = append(captured, energy(in))
+		canceller.Process(in)
+		left = append(left, energy(in))
+		canceller.Playback(out)
+	}
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func TestEchoCanceller(t *testing.T) {
+	farPath, nearPath := writeEchoFixtures(t, 200, func(int) []float32 {
+		return nil
+	})
+	captured, left := cancelWAV(t, farPath, nearPath)
+	//The delay is found and the filter converges within a few seconds
+	if erle := 10 * math.Log10(sum(captured[150:])/sum(left[150:])); erle < 30 {
+		t.Fatalf("Echo is attenuated by %.1f dB, want at least 30 dB", erle)
+	}
+}
+
+func TestEchoCancellerDoubleTalk(t *testing.T) {
+	//The near end talks over the echo, louder than it, once the filter converged
+	tone := 0
+	farPath, nearPath := writeEchoFixtures(t, 250, func(frame int) []float32 {
+		if frame < 150 || frame >= 175 {
+			return nil
+		}
+		pcm := make([]float32, FrameSize())
+		for i := range pcm {
+			pcm[i] = float32(0.5 * math.Sin(2*math.Pi*440*float64(tone)/SampleRate))
+			tone++
+		}
+		return pcm
+	})
+	captured, left := cancelWAV(t, farPath, nearPath)
+
+	//Its voice must go through, and the filter must not diverge
+	if ratio := sum(left[150:175]) / sum(captured[150:175]); ratio < 0.5 {
+		t.Fatalf("Double talk keeps %.0f%% of the captured energy, want at least 50%%", ratio*100)
+	}
+	if erle := 10 * math.Log10(sum(captured[200:])/sum(left[200:])); erle < 30 {
+		t.Fatalf("Echo is attenuated by %.1f dB after double talk, want at least 30 dB", erle)
+	}
+}
+
+func BenchmarkEchoCanceller(b *testing.B) {
+	canceller := NewEchoCanceller()
+	room := newEchoRoom()
+	out := interleave(room.playback())
+	in := interleave(room.capture(out))
+	pcm := make([]float32, len(in))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(pcm, in)
+		canceller.Process(pcm)
+		canceller.Playback(out)
+	}
+}
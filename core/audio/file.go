@@ -0,0 +1,121 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+type fileDevice struct {
+	input  *WAVReader
+	output *WAVWriter
+	files  []*os.File
+	ended  bool
+}
+
+//NewFileDevice creates a Device capturing from a WAV stream and playing into another one.
+//Either may be nil, in which case silence is captured or playback is discarded.
+//Reads do not block, wrap the device with Realtime to run it at the pace of a sound card
+func NewFileDevice(input io.Reader, output io.WriteSeeker) (Device, error) {
+	device := &fileDevice{}
+	if input != nil {
+		reader, err := NewWAVReader(input)
+		if err != nil {
+			return nil, err
+		}
+		if reader.SampleRate != SampleRate || reader.Channels != Channels() {
+			return nil, errors.New("WAV input must be at 48000 Hz with as many channels as the audio format")
+		}
+		device.input = reader
+	}
+	if output != nil {
+		writer, err := NewWAVWriter(output, SampleRate, Channels())
+		if err != nil {
+			return nil, err
+		}
+		device.output = writer
+	}
+	return device, nil
+}
+
+//OpenFileDevice is NewFileDevice on files, an empty path disables the corresponding direction
+func OpenFileDevice(inputPath, outputPath string) (Device, error) {
+	var input io.Reader
+	var output io.WriteSeeker
+	var files []*os.File
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	if len(inputPath) > 0 {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		input = file
+	}
+	if len(outputPath) > 0 {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, file)
+		output = file
+	}
+
+	device, err := NewFileDevice(input, output)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	device.(*fileDevice).files = files
+	return device, nil
+}
+
+//Read returns io.EOF once the input is exhausted, the last frame is padded with silence
+func (device *fileDevice) Read(in []float32) error {
+	if device.ended {
+		return io.EOF
+	}
+	n := 0
+	if device.input != nil {
+		var err error
+		n, err = device.input.Read(in)
+		if err == io.EOF || (err == nil && n < len(in)) {
+			device.ended = true
+		} else if err != nil {
+			return err
+		}
+		if n == 0 && device.ended {
+			return io.EOF
+		}
+	}
+	for i := n; i < len(in); i++ {
+		in[i] = 0
+	}
+	return nil
+}
+
+func (device *fileDevice) Write(out []float32) error {
+	if device.output == nil {
+		return nil
+	}
+	return device.output.Write(out)
+}
+
+func (device *fileDevice) Close() error {
+	var err error
+	if device.output != nil {
+		err = device.output.Close()
+	}
+	for _, file := range device.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
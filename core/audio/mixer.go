@@ -0,0 +1,152 @@
+package audio
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	//limiterThreshold is the peak the limiter keeps the mix under
+	limiterThreshold = 0.9
+	//limiterRelease is how fast the limiter gain recovers after a loud frame, per frame
+	limiterRelease = 0.1
+	//softClipKnee is where soft clipping starts to bend samples toward 1
+	softClipKnee = 0.8
+	//speakingThreshold is the RMS above which a source is considered to be speaking
+	speakingThreshold = 0.02
+)
+
+//Level refers to the loudness of a frame
+type Level struct {
+	RMS  float32
+	Peak float32
+}
+
+//MeasureLevel computes the level of a frame
+func MeasureLevel(pcm []float32) Level {
+	peak := float32(0)
+	for _, v := range pcm {
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+	}
+	return Level{RMS: float32(rms(pcm)), Peak: peak}
+}
+
+//Speaking tells whether the level is loud enough to be speech rather than noise
+func (level Level) Speaking() bool {
+	return level.RMS > speakingThreshold
+}
+
+//Source is one contribution to a mix
+type Source struct {
+	//Key identifies the source when querying its level
+	Key interface{}
+	//PCM is the decoded frame, nil if the source has nothing to play
+	PCM  []float32
+	Gain float32
+	//Pan moves the source between the left (-1) and right (1) channels of a stereo mix, 0 is centered
+	Pan float32
+}
+
+//Mixer sums frames from several sources into one playback frame, limiting the sum so it does not clip
+type Mixer struct {
+	sources []Source
+	gain    float32
+
+	mutex  sync.Mutex
+	levels map[interface{}]Level
+}
+
+//NewMixer creates a Mixer
+func NewMixer() *Mixer {
+	return &Mixer{
+		gain:   1,
+		levels: map[interface{}]Level{},
+	}
+}
+
+//Add queues a source for the next Mix
+func (mixer *Mixer) Add(source Source) {
+	mixer.sources = append(mixer.sources, source)
+}
+
+//Mix writes the mix of the sources added since the last Mix into out
+func (mixer *Mixer) Mix(out []float32) {
+	for i := range out {
+		out[i] = 0
+	}
+
+	levels := make(map[interface{}]Level, len(mixer.sources))
+	for _, source := range mixer.sources {
+		levels[source.Key] = MeasureLevel(source.PCM)
+		gains := []float32{source.Gain}
+		if Channels() == 2 {
+			left, right := balance(source.Pan)
+			gains = []float32{source.Gain * left, source.Gain * right}
+		}
+		for i := 0; i < len(source.PCM) && i < len(out); i++ {
+			out[i] += source.PCM[i] * gains[i%len(gains)]
+		}
+	}
+	mixer.sources = mixer.sources[:0]
+
+	mixer.limit(out)
+
+	mixer.mutex.Lock()
+	mixer.levels = levels
+	mixer.mutex.Unlock()
+}
+
+//limit applies a gain that drops instantly on loud frames and recovers slowly, then soft clips what remains
+func (mixer *Mixer) limit(out []float32) {
+	target := float32(1)
+	if peak := MeasureLevel(out).Peak; peak > limiterThreshold {
+		target = limiterThreshold / peak
+	}
+	if target < mixer.gain {
+		mixer.gain = target
+	} else {
+		mixer.gain += (target - mixer.gain) * limiterRelease
+	}
+
+	for i := range out {
+		out[i] = softClip(out[i] * mixer.gain)
+	}
+}
+
+//balance returns the gains of the left and right channels for a pan position,
+//the channel the source moves toward keeps its full level while the other fades out
+func balance(pan float32) (float32, float32) {
+	if pan < -1 {
+		pan = -1
+	} else if pan > 1 {
+		pan = 1
+	}
+	if pan < 0 {
+		return 1, 1 + pan
+	}
+	return 1 - pan, 1
+}
+
+func softClip(v float32) float32 {
+	magnitude := float64(v)
+	sign := 1.0
+	if magnitude < 0 {
+		magnitude, sign = -magnitude, -1
+	}
+	if magnitude <= softClipKnee {
+		return v
+	}
+	return float32(sign * (softClipKnee + (1-softClipKnee)*math.Tanh((magnitude-softClipKnee)/(1-softClipKnee))))
+}
+
+//Level returns the level of a source in the last Mix
+func (mixer *Mixer) Level(key interface{}) Level {
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+	return mixer.levels[key]
+}
@@ -0,0 +1,299 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+)
+
+const (
+	//oggPreSkip is the number of samples to drop at the start of a stream, the lookahead of libopus encoders
+	oggPreSkip = 312
+	//oggPageSamples is how much audio a page holds before it is written, bounding what a crash loses
+	oggPageSamples = SampleRate
+	//oggMaximumSegments is the number of lacing values a page can hold
+	oggMaximumSegments = 255
+
+	oggContinued = 0x01
+	oggFirstPage = 0x02
+	oggLastPage  = 0x04
+)
+
+//oggCRCTable is the lookup table of the CRC-32 used by Ogg, polynomial 0x04c11db7 without reflection
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+//PacketSamples returns the number of samples per channel an Opus packet decodes to, read from its TOC byte
+func PacketSamples(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, errors.New("Empty Opus packet")
+	}
+	config := int(data[0] >> 3)
+	var frameSize int
+	switch {
+	case config < 12:
+		//SILK frames of 10, 20, 40 or 60 ms
+		frameSize = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		//Hybrid frames of 10 or 20 ms
+		frameSize = []int{480, 960}[config%2]
+	default:
+		//CELT frames of 2.5, 5, 10 or 20 ms
+		frameSize = []int{120, 240, 480, 960}[config%4]
+	}
+
+	switch data[0] & 0x03 {
+	case 0:
+		return frameSize, nil
+	case 1, 2:
+		return 2 * frameSize, nil
+	}
+	if len(data) < 2 {
+		return 0, errors.New("Opus packet is missing its frame count")
+	}
+	return int(data[1]&0x3f) * frameSize, nil
+}
+
+//OggWriter writes Opus packets to an Ogg Opus stream as described by RFC 7845
+type OggWriter struct {
+	writer   io.Writer
+	channels int
+	serial   uint32
+	sequence uint32
+	//granule is the number of samples in the stream, including those waiting in the page
+	granule int64
+
+	segments    []byte
+	body        []byte
+	pageSamples int
+}
+
+//NewOggWriter writes the headers of a stream of channels channels to w
+func NewOggWriter(w io.Writer, channels int) (*OggWriter, error) {
+	writer := &OggWriter{writer: w, channels: channels, serial: rand.Uint32()}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], oggPreSkip)
+	binary.LittleEndian.PutUint32(head[12:], SampleRate)
+	writer.addPacket(head)
+	if err := writer.flush(oggFirstPage); err != nil {
+		return nil, err
+	}
+
+	vendor := "spear"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	writer.addPacket(tags)
+	if err := writer.flush(0); err != nil {
+		return nil, err
+	}
+	writer.granule = oggPreSkip
+	return writer, nil
+}
+
+//WritePacket appends an Opus packet to the stream
+func (writer *OggWriter) WritePacket(data []byte) error {
+	samples, err := PacketSamples(data)
+	if err != nil {
+		return err
+	}
+	if len(data) >= oggMaximumSegments*255 {
+		return errors.New("Opus packet is too large for an Ogg page")
+	}
+	//A packet never spans two pages, so the page is written first if the packet does not fit
+	if len(writer.segments)+len(data)/255+1 > oggMaximumSegments || writer.pageSamples >= oggPageSamples {
+		if err := writer.flush(0); err != nil {
+			return err
+		}
+	}
+	writer.addPacket(data)
+	writer.granule += int64(samples)
+	writer.pageSamples += samples
+	return nil
+}
+
+//Skip advances the stream by about samples samples, in steps of 2.5 ms, with empty packets
+//that decoders conceal as a lost frame would be
+func (writer *OggWriter) Skip(samples int) error {
+	//Code 3 packets of up to six empty 20 ms CELT frames, then single shorter frames for the rest
+	stereo := byte(0)
+	if writer.channels == 2 {
+		stereo = 0x04
+	}
+	for samples >= 960 {
+		frames := samples / 960
+		if frames > 6 {
+			frames = 6
+		}
+		if err := writer.WritePacket([]byte{31<<3 | stereo | 0x03, byte(frames)}); err != nil {
+			return err
+		}
+		samples -= frames * 960
+	}
+	for config, size := 30, 480; size >= 120; config, size = config-1, size/2 {
+		if samples >= size {
+			if err := writer.WritePacket([]byte{byte(config)<<3 | stereo}); err != nil {
+				return err
+			}
+			samples -= size
+		}
+	}
+	return nil
+}
+
+//Samples returns the number of samples per channel written, excluding the pre-skip
+func (writer *OggWriter) Samples() int64 {
+	return writer.granule - oggPreSkip
+}
+
+//Close writes the last page of the stream, it does not close the underlying writer
+func (writer *OggWriter) Close() error {
+	return writer.flush(oggLastPage)
+}
+
+func (writer *OggWriter) addPacket(data []byte) {
+	for n := len(data); n >= 255; n -= 255 {
+		writer.segments = append(writer.segments, 255)
+	}
+	writer.segments = append(writer.segments, byte(len(data)%255))
+	writer.body = append(writer.body, data...)
+}
+
+func (writer *OggWriter) flush(flags byte) error {
+	if len(writer.segments) == 0 && flags == 0 {
+		return nil
+	}
+	page := make([]byte, 27, 27+len(writer.segments)+len(writer.body))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(writer.granule))
+	binary.LittleEndian.PutUint32(page[14:], writer.serial)
+	binary.LittleEndian.PutUint32(page[18:], writer.sequence)
+	page[26] = byte(len(writer.segments))
+	page = append(page, writer.segments...)
+	page = append(page, writer.body...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	writer.sequence++
+	writer.segments = writer.segments[:0]
+	writer.body = writer.body[:0]
+	writer.pageSamples = 0
+	_, err := writer.writer.Write(page)
+	return err
+}
+
+//OggReader reads the packets of the first logical stream of an Ogg stream
+type OggReader struct {
+	reader io.Reader
+	serial uint32
+	//started tells whether the first page was read, its serial number being the one of the stream
+	started  bool
+	ended    bool
+	segments []byte
+	body     []byte
+	//packet is the part of a packet continued on the next page
+	packet []byte
+}
+
+//NewOggReader creates an OggReader
+func NewOggReader(r io.Reader) *OggReader {
+	return &OggReader{reader: r}
+}
+
+//ReadPacket returns the next packet, or io.EOF after the last one
+func (reader *OggReader) ReadPacket() ([]byte, error) {
+	for {
+		for len(reader.segments) > 0 {
+			size := int(reader.segments[0])
+			reader.segments = reader.segments[1:]
+			reader.packet = append(reader.packet, reader.body[:size]...)
+			reader.body = reader.body[size:]
+			if size < 255 {
+				packet := reader.packet
+				reader.packet = nil
+				return packet, nil
+			}
+		}
+		if reader.ended {
+			return nil, io.EOF
+		}
+		if err := reader.readPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (reader *OggReader) readPage() error {
+	header := make([]byte, 27)
+	for {
+		if _, err := io.ReadFull(reader.reader, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return err
+		}
+		if string(header[:4]) != "OggS" {
+			return errors.New("Not an Ogg stream")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(reader.reader, segments); err != nil {
+			return err
+		}
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(reader.reader, body); err != nil {
+			return err
+		}
+
+		page := append(append(header, segments...), body...)
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if oggCRC(page) != crc {
+			return errors.New("Corrupted Ogg page")
+		}
+
+		//Pages of other multiplexed streams are skipped
+		serial := binary.LittleEndian.Uint32(header[14:])
+		if !reader.started {
+			reader.started, reader.serial = true, serial
+		} else if serial != reader.serial {
+			continue
+		}
+		if header[5]&oggContinued == 0 {
+			reader.packet = nil
+		}
+		reader.ended = header[5]&oggLastPage != 0
+		reader.segments, reader.body = segments, body
+		return nil
+	}
+}
@@ -0,0 +1,316 @@
+package audio
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+//DeviceInfo describes a sound card usable by OpenPortAudio
+type DeviceInfo struct {
+	Index          int
+	Name           string
+	HostAPI        string
+	InputChannels  int
+	OutputChannels int
+	SampleRate     float64
+	DefaultInput   bool
+	DefaultOutput  bool
+}
+
+//ListDevices returns the sound cards known to PortAudio
+func ListDevices() ([]DeviceInfo, error) {
+	if err := portaudio.Initialize(); err != nil {
+		return nil, err
+	}
+	defer portaudio.Terminate()
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	defaultInput, _ := portaudio.DefaultInputDevice()
+	defaultOutput, _ := portaudio.DefaultOutputDevice()
+
+	infos := []DeviceInfo{}
+	for _, device := range devices {
+		info := DeviceInfo{
+			Index:          device.Index,
+			Name:           device.Name,
+			InputChannels:  device.MaxInputChannels,
+			OutputChannels: device.MaxOutputChannels,
+			SampleRate:     device.DefaultSampleRate,
+			DefaultInput:   device == defaultInput,
+			DefaultOutput:  device == defaultOutput,
+		}
+		if device.HostApi != nil {
+			info.HostAPI = device.HostApi.Name
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+//findDevice selects a sound card by index or name, an empty selector picks the default one
+func findDevice(selector string, input bool) (*portaudio.DeviceInfo, error) {
+	if len(selector) == 0 {
+		if input {
+			return portaudio.DefaultInputDevice()
+		}
+		return portaudio.DefaultOutputDevice()
+	}
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	usable := func(device *portaudio.DeviceInfo) bool {
+		if input {
+			return device.MaxInputChannels >= Channels()
+		}
+		return device.MaxOutputChannels >= Channels()
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		for _, device := range devices {
+			if device.Index == index && usable(device) {
+				return device, nil
+			}
+		}
+	}
+	//Exact names take precedence over partial ones
+	for _, device := range devices {
+		if strings.EqualFold(device.Name, selector) && usable(device) {
+			return device, nil
+		}
+	}
+	for _, device := range devices {
+		if strings.Contains(strings.ToLower(device.Name), strings.ToLower(selector)) && usable(device) {
+			return device, nil
+		}
+	}
+
+	if input {
+		return nil, errors.New("No capture device matches " + selector)
+	}
+	return nil, errors.New("No playback device matches " + selector)
+}
+
+type portAudioStream struct {
+	stream  *portaudio.Stream
+	in, out []float32
+
+	//The sound cards run at rate, audio is resampled from and to SampleRate if it differs
+	rate     int
+	capture  *Resampler
+	playback *Resampler
+	//captured holds resampled input not read yet, queued resampled output not written yet
+	captured []float32
+	queued   []float32
+}
+
+func openPortAudioStream(input, output string) (*portAudioStream, error) {
+	if err := portaudio.Initialize(); err != nil {
+		return nil, err
+	}
+
+	inDevice, err := findDevice(input, true)
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+	outDevice, err := findDevice(output, false)
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+
+	params := portaudio.HighLatencyParameters(inDevice, outDevice)
+	params.Input.Channels = Channels()
+	params.Output.Channels = Channels()
+
+	//Opus runs at 48000 Hz, sound cards that do not support it run at their own rate
+	var s *portAudioStream
+	for _, rate := range []float64{SampleRate, inDevice.DefaultSampleRate, outDevice.DefaultSampleRate} {
+		params.SampleRate = rate
+		params.FramesPerBuffer = int(rate * FrameDuration().Seconds())
+		candidate := &portAudioStream{
+			in:   make([]float32, params.FramesPerBuffer*Channels()),
+			out:  make([]float32, params.FramesPerBuffer*Channels()),
+			rate: int(rate),
+		}
+		if portaudio.IsFormatSupported(params, candidate.in, candidate.out) == nil {
+			s = candidate
+			break
+		}
+	}
+	if s == nil {
+		portaudio.Terminate()
+		return nil, errors.New("The capture and playback devices have no sample rate in common")
+	}
+	if s.rate != SampleRate {
+		s.capture = NewResampler(s.rate, SampleRate, Channels())
+		s.playback = NewResampler(SampleRate, s.rate, Channels())
+	}
+
+	stream, err := portaudio.OpenStream(params, s.in, s.out)
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		portaudio.Terminate()
+		return nil, err
+	}
+	s.stream = stream
+	return s, nil
+}
+
+func (s *portAudioStream) read(in []float32) error {
+	if s.capture == nil {
+		err := s.stream.Read()
+		copy(in, s.in)
+		return err
+	}
+
+	var err error
+	for len(s.captured) < len(in) {
+		//Overflows only mean that some samples were dropped
+		if err = s.stream.Read(); err != nil && err != portaudio.InputOverflowed {
+			return err
+		}
+		s.captured = append(s.captured, s.capture.Process(s.in)...)
+	}
+	copy(in, s.captured)
+	s.captured = append(s.captured[:0], s.captured[len(in):]...)
+	return err
+}
+
+func (s *portAudioStream) write(out []float32) error {
+	if s.playback == nil {
+		copy(s.out, out)
+		return s.stream.Write()
+	}
+
+	s.queued = append(s.queued, s.playback.Process(out)...)
+	var err error
+	for len(s.queued) >= len(s.out) {
+		copy(s.out, s.queued)
+		s.queued = append(s.queued[:0], s.queued[len(s.out):]...)
+		//Underflows only mean that a gap was played
+		if err = s.stream.Write(); err != nil && err != portaudio.OutputUnderflowed {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *portAudioStream) close() error {
+	s.stream.Stop()
+	err := s.stream.Close()
+	portaudio.Terminate()
+	return err
+}
+
+//PortAudioDevice is a Device backed by PortAudio, its sound cards can be switched while it runs
+type PortAudioDevice struct {
+	mutex         sync.Mutex
+	stream        *portAudioStream
+	input, output string
+}
+
+//OpenPortAudio opens the capture and playback devices matching input and output by index or name,
+//an empty selector picks the system default
+func OpenPortAudio(input, output string) (*PortAudioDevice, error) {
+	stream, err := openPortAudioStream(input, output)
+	if err != nil {
+		return nil, err
+	}
+	return &PortAudioDevice{stream: stream, input: input, output: output}, nil
+}
+
+//errNoStream is returned by a device whose sound cards could neither be switched nor reopened, or that was closed
+var errNoStream = errors.New("No sound card is open, choose other devices")
+
+//Switch reopens the device on other sound cards, the current ones are reopened if that fails
+func (device *PortAudioDevice) Switch(input, output string) error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+
+	//Some backends cannot open a sound card twice, so the current stream goes first
+	if device.stream != nil {
+		device.stream.close()
+		device.stream = nil
+	}
+	stream, err := openPortAudioStream(input, output)
+	if err != nil {
+		if stream, reopenErr := openPortAudioStream(device.input, device.output); reopenErr == nil {
+			device.stream = stream
+		}
+		return err
+	}
+	device.stream, device.input, device.output = stream, input, output
+	return nil
+}
+
+//SampleRate returns the rate the sound cards run at, audio is resampled if it is not SampleRate.
+//It returns 0 when no sound card is open
+func (device *PortAudioDevice) SampleRate() int {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.stream == nil {
+		return 0
+	}
+	return device.stream.rate
+}
+
+//Selection returns the selectors of the sound cards in use
+func (device *PortAudioDevice) Selection() (input, output string) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return device.input, device.output
+}
+
+func (device *PortAudioDevice) Read(in []float32) error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.stream == nil {
+		return errNoStream
+	}
+
+	err := device.stream.read(in)
+	//Overflows only mean that some samples were dropped
+	if err == portaudio.InputOverflowed {
+		return nil
+	}
+	return err
+}
+
+func (device *PortAudioDevice) Write(out []float32) error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.stream == nil {
+		return errNoStream
+	}
+
+	err := device.stream.write(out)
+	if err == portaudio.OutputUnderflowed {
+		return nil
+	}
+	return err
+}
+
+func (device *PortAudioDevice) Close() error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.stream == nil {
+		return errNoStream
+	}
+	err := device.stream.close()
+	device.stream = nil
+	return err
+}
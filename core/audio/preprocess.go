@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"math"
+)
+
+const (
+	//gateRamp is the fraction of the remaining gain change applied per sample when a gate opens or closes
+	gateRamp = 0.005
+
+	//agcTarget is the RMS the AGC brings speech to
+	agcTarget      = 0.1
+	agcMinimumGain = 0.1
+	agcMaximumGain = 10
+	//agcAttack and agcRelease are the fractions of the gain error corrected per frame,
+	//loud speech is turned down faster than quiet speech is turned up
+	agcAttack  = 0.3
+	agcRelease = 0.02
+)
+
+//Processor transforms a captured frame in place
+type Processor interface {
+	Process(pcm []float32)
+}
+
+//Chain runs processors one after the other
+type Chain []Processor
+
+//Process runs every processor of the chain on the frame
+func (chain Chain) Process(pcm []float32) {
+	for _, processor := range chain {
+		processor.Process(pcm)
+	}
+}
+
+//HighPass is a second order Butterworth high-pass filter removing rumble and DC offset
+type HighPass struct {
+	b0, b1, b2, a1, a2 float64
+	//Filter state of each channel
+	x1, x2, y1, y2 []float64
+}
+
+//NewHighPass creates a HighPass cutting frequencies below cutoff Hz
+func NewHighPass(cutoff float64) *HighPass {
+	w0 := 2 * math.Pi * cutoff / SampleRate
+	//Butterworth response, Q = 1/sqrt(2)
+	alpha := math.Sin(w0) / math.Sqrt2
+	cos := math.Cos(w0)
+	a0 := 1 + alpha
+	return &HighPass{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+		x1: make([]float64, Channels()),
+		x2: make([]float64, Channels()),
+		y1: make([]float64, Channels()),
+		y2: make([]float64, Channels()),
+	}
+}
+
+//Process filters each channel of the frame
+func (filter *HighPass) Process(pcm []float32) {
+	for i, v := range pcm {
+		c := i % len(filter.x1)
+		x := float64(v)
+		y := filter.b0*x + filter.b1*filter.x1[c] + filter.b2*filter.x2[c] - filter.a1*filter.y1[c] - filter.a2*filter.y2[c]
+		filter.x2[c], filter.x1[c] = filter.x1[c], x
+		filter.y2[c], filter.y1[c] = filter.y1[c], y
+		pcm[i] = float32(y)
+	}
+}
+
+//NoiseGate attenuates frames that a voice activity detector considers to be noise
+type NoiseGate struct {
+	//Attenuation is the gain applied to noise, 0 closes the gate completely
+	Attenuation float32
+
+	vad  *VAD
+	gain float32
+}
+
+//NewNoiseGate creates a NoiseGate attenuating noise by 20 dB
+func NewNoiseGate() *NoiseGate {
+	return &NoiseGate{Attenuation: 0.1, vad: NewVAD(), gain: 1}
+}
+
+//Process gates the frame, ramping the gain to avoid clicks
+func (gate *NoiseGate) Process(pcm []float32) {
+	target := gate.Attenuation
+	if gate.vad.Active(pcm) {
+		target = 1
+	}
+	for i := range pcm {
+		gate.gain += (target - gate.gain) * gateRamp
+		pcm[i] *= gate.gain
+	}
+}
+
+//AGC brings speech to a steady level whatever the distance to the microphone
+type AGC struct {
+	vad  *VAD
+	gain float64
+}
+
+//NewAGC creates an automatic gain control
+func NewAGC() *AGC {
+	return &AGC{vad: NewVAD(), gain: 1}
+}
+
+//Process adapts the gain on speech frames and applies it, ramping from the gain of the previous frame
+func (agc *AGC) Process(pcm []float32) {
+	previous := agc.gain
+	//Frames kept active by the hangover are pauses, adapting on them would raise the gain between words
+	level := rms(pcm)
+	if agc.vad.Active(pcm) && level > minimumSpeechLevel && level > float64(agc.vad.NoiseLevel())*activationRatio {
+		desired := math.Max(agcMinimumGain, math.Min(agcMaximumGain, agcTarget/level))
+		if desired < agc.gain {
+			agc.gain += (desired - agc.gain) * agcAttack
+		} else {
+			agc.gain += (desired - agc.gain) * agcRelease
+		}
+	}
+
+	for i := range pcm {
+		gain := previous + (agc.gain-previous)*float64(i)/float64(len(pcm))
+		pcm[i] = softClip(float32(float64(pcm[i]) * gain))
+	}
+}
@@ -0,0 +1,133 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+//speechFrame returns a frame of a 440 Hz tone with a little low frequency hum
+func speechFrame() []float32 {
+	pcm := make([]float32, FrameSize()*Channels())
+	for i := range pcm {
+		t := float64(i/Channels()) / SampleRate
+		pcm[i] = float32(0.3*math.Sin(2*math.Pi*440*t) + 0.05*math.Sin(2*math.Pi*50*t))
+	}
+	return pcm
+}
+
+//tone generates frames of a sine wave, its phase continuing from one frame to the next
+type tone struct {
+	frequency float64
+	phase     float64
+}
+
+//frame returns the next frame of the tone at the given amplitude
+func (tone *tone) frame(amplitude float64) []float32 {
+	pcm := make([]float32, FrameSize()*Channels())
+	for i := 0; i < FrameSize(); i++ {
+		for c := 0; c < Channels(); c++ {
+			pcm[i*Channels()+c] = float32(amplitude * math.Sin(tone.phase))
+		}
+		tone.phase += 2 * math.Pi * tone.frequency / SampleRate
+	}
+	return pcm
+}
+
+//gain runs frames of a tone through processor and returns the gain over the last frame
+func gain(processor Processor, tone *tone, amplitude float64, frames int) float64 {
+	var in, out float64
+	for f := 0; f < frames; f++ {
+		pcm := tone.frame(amplitude)
+		in = rms(pcm)
+		processor.Process(pcm)
+		out = rms(pcm)
+	}
+	return out / in
+}
+
+func TestHighPass(t *testing.T) {
+	tests := []struct {
+		frequency        float64
+		minimum, maximum float64
+	}{
+		//80 Hz second order Butterworth: -9 dB at 50 Hz, flat at 1 kHz
+		{50, 0.3, 0.4},
+		{1000, 0.99, 1.01},
+	}
+	for _, test := range tests {
+		if g := gain(NewHighPass(80), &tone{frequency: test.frequency}, 0.5, 25); g < test.minimum || g > test.maximum {
+			t.Errorf("Gain at %.0f Hz is %.3f, want between %.2f and %.2f", test.frequency, g, test.minimum, test.maximum)
+		}
+	}
+}
+
+func TestNoiseGate(t *testing.T) {
+	gate := NewNoiseGate()
+	hum := &tone{frequency: 200}
+	if g := gain(gate, hum, 0.001, 25); g > float64(gate.Attenuation)*1.1 {
+		t.Fatalf("Gain on low-level noise is %.3f, want %.2f", g, gate.Attenuation)
+	}
+	//The gate opens within a frame of speech
+	speech := &tone{frequency: 440}
+	gate.Process(speech.frame(0.3))
+	if g := gain(gate, speech, 0.3, 1); g < 0.99 {
+		t.Fatalf("Gain on speech is %.3f, want 1", g)
+	}
+}
+
+func TestAGC(t *testing.T) {
+	for _, amplitude := range []float64{0.05, 0.5} {
+		agc := NewAGC()
+		speech := &tone{frequency: 300}
+		//Words of five frames separated by pauses of two, shorter than the hangover of the detector
+		var levels []float64
+		for f := 0; f < 400; f++ {
+			level := amplitude
+			if f%7 >= 5 {
+				level = 0.0002
+			}
+			pcm := speech.frame(level)
+			agc.Process(pcm)
+			if f >= 300 && level == amplitude {
+				levels = append(levels, rms(pcm))
+			}
+		}
+
+		minimum, maximum := levels[0], levels[0]
+		for _, level := range levels {
+			minimum, maximum = math.Min(minimum, level), math.Max(maximum, level)
+		}
+		if math.Abs(minimum-agcTarget) > agcTarget*0.1 || math.Abs(maximum-agcTarget) > agcTarget*0.1 {
+			t.Errorf("Speech of amplitude %.2f is brought between %.3f and %.3f, want %.2f", amplitude, minimum, maximum, agcTarget)
+		}
+		if maximum/minimum > 1.02 {
+			t.Errorf("Speech of amplitude %.2f pumps by %.1f%%", amplitude, (maximum/minimum-1)*100)
+		}
+	}
+}
+
+func benchmarkProcessor(b *testing.B, processor Processor) {
+	source := speechFrame()
+	pcm := make([]float32, len(source))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(pcm, source)
+		processor.Process(pcm)
+	}
+}
+
+func BenchmarkHighPass(b *testing.B) {
+	benchmarkProcessor(b, NewHighPass(80))
+}
+
+func BenchmarkNoiseGate(b *testing.B) {
+	benchmarkProcessor(b, NewNoiseGate())
+}
+
+func BenchmarkAGC(b *testing.B) {
+	benchmarkProcessor(b, NewAGC())
+}
+
+func BenchmarkChain(b *testing.B) {
+	benchmarkProcessor(b, Chain{NewHighPass(80), NewNoiseGate(), NewAGC()})
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"math"
+)
+
+const (
+	//resamplerZeroCrossings is the number of sinc lobes on each side of the filter kernel
+	resamplerZeroCrossings = 16
+	//resamplerPhases is the resolution of the kernel table, per input sample
+	resamplerPhases = 256
+	//resamplerBandwidth keeps the cutoff a little under the Nyquist frequency so that the transition band does not alias
+	resamplerBandwidth = 0.95
+)
+
+//Resampler converts a stream of interleaved audio between two sample rates with a windowed sinc filter
+type Resampler struct {
+	channels int
+	//step is the distance between two output samples, in input samples
+	step float64
+	//halfWidth is the number of input samples on each side of an output sample that contribute to it
+	halfWidth int
+	//kernel holds the filter at resamplerPhases points per input sample, from the center outward
+	kernel []float32
+	//taps holds the kernel values of the output sample being computed
+	taps []float32
+
+	//history holds the input samples still needed, position being where the next output sample lies in it
+	history  []float32
+	position float64
+	output   []float32
+}
+
+//NewResampler creates a Resampler from one sample rate to another
+func NewResampler(from, to, channels int) *Resampler {
+	cutoff := resamplerBandwidth
+	if to < from {
+		//Downsampling must also remove what the lower rate cannot represent
+		cutoff *= float64(to) / float64(from)
+	}
+	halfWidth := int(math.Ceil(resamplerZeroCrossings / cutoff))
+
+	kernel := make([]float32, halfWidth*resamplerPhases+2)
+	for i := range kernel {
+		d := float64(i) / resamplerPhases
+		if d > float64(halfWidth) {
+			break
+		}
+		x := math.Pi * cutoff * d
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(x) / x
+		}
+		//Blackman window
+		w := d / float64(halfWidth)
+		window := 0.42 + 0.5*math.Cos(math.Pi*w) + 0.08*math.Cos(2*math.Pi*w)
+		kernel[i] = float32(cutoff * sinc * window)
+	}
+
+	return &Resampler{
+		channels:  channels,
+		step:      float64(from) / float64(to),
+		halfWidth: halfWidth,
+		kernel:    kernel,
+		taps:      make([]float32, 2*halfWidth),
+		//Samples before the stream are silence, the first output sample is produced once halfWidth input samples follow it
+		history:  make([]float32, halfWidth*channels),
+		position: float64(halfWidth),
+	}
+}
+
+//Process converts a block of input and returns the output available so far,
+//the returned slice is only valid until the next call
+func (resampler *Resampler) Process(in []float32) []float32 {
+	channels := resampler.channels
+	resampler.history = append(resampler.history, in...)
+	frames := len(resampler.history) / channels
+	output := resampler.output[:0]
+
+	for int(resampler.position)+resampler.halfWidth < frames {
+		center := int(resampler.position)
+		fraction := resampler.position - float64(center)
+		first := center - resampler.halfWidth + 1
+		for j := range resampler.taps {
+			resampler.taps[j] = resampler.tap(math.Abs(float64(first+j-center) - fraction))
+		}
+		for c := 0; c < channels; c++ {
+			var sum float32
+			for j, tap := range resampler.taps {
+				sum += resampler.history[(first+j)*channels+c] * tap
+			}
+			output = append(output, sum)
+		}
+		resampler.position += resampler.step
+	}
+
+	//Samples that no future output sample reaches are dropped
+	if drop := int(resampler.position) - resampler.halfWidth + 1; drop > 0 {
+		resampler.history = append(resampler.history[:0], resampler.history[drop*channels:]...)
+		resampler.position -= float64(drop)
+	}
+	resampler.output = output
+	return output
+}
+
+//tap interpolates the kernel at a distance from its center, in input samples
+func (resampler *Resampler) tap(distance float64) float32 {
+	index := distance * resamplerPhases
+	i := int(index)
+	if i+1 >= len(resampler.kernel) {
+		return 0
+	}
+	fraction := float32(index - float64(i))
+	return resampler.kernel[i] + (resampler.kernel[i+1]-resampler.kernel[i])*fraction
+}
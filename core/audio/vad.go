@@ -0,0 +1,80 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	//activationRatio is how far above the noise floor a frame must be to count as speech
+	activationRatio = 3
+	//minimumSpeechLevel is the RMS below which a frame is never speech, whatever the noise floor is
+	minimumSpeechLevel = 0.002
+	//hangoverDuration keeps a detector active shortly after speech ends so that word endings are not cut
+	hangoverDuration = time.Millisecond * 320
+
+	initialNoiseFloor = 0.01
+	floorAttack       = 0.2
+	floorRelease      = 1.005
+)
+
+//VAD is an energy based voice activity detector with an adaptive noise floor
+type VAD struct {
+	noiseFloor float64
+	hangover   int
+}
+
+//NewVAD creates a new voice activity detector
+func NewVAD() *VAD {
+	return &VAD{noiseFloor: initialNoiseFloor}
+}
+
+//Active tells whether a frame contains speech and updates the noise floor
+func (vad *VAD) Active(pcm []float32) bool {
+	level := rms(pcm)
+
+	//The floor follows quiet frames quickly and creeps up slowly so that speech does not raise it
+	if level < vad.noiseFloor {
+		vad.noiseFloor += (level - vad.noiseFloor) * floorAttack
+	} else {
+		vad.noiseFloor *= floorRelease
+	}
+
+	if level > minimumSpeechLevel && level > vad.noiseFloor*activationRatio {
+		vad.hangover = int(hangoverDuration / FrameDuration())
+		return true
+	}
+	if vad.hangover > 0 {
+		vad.hangover--
+		return true
+	}
+	return false
+}
+
+//NoiseLevel returns the estimated RMS of the background noise
+func (vad *VAD) NoiseLevel() float32 {
+	return float32(vad.noiseFloor)
+}
+
+//ComfortNoise generates a frame of white noise with the given RMS level
+func ComfortNoise(level float32) []float32 {
+	//Uniform noise in [-1, 1] has an RMS of 1/sqrt(3)
+	amplitude := level * float32(math.Sqrt(3))
+	pcm := make([]float32, FrameSize()*Channels())
+	for i := range pcm {
+		pcm[i] = (rand.Float32()*2 - 1) * amplitude
+	}
+	return pcm
+}
+
+func rms(pcm []float32) float64 {
+	if len(pcm) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(pcm)))
+}
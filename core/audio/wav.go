@@ -0,0 +1,171 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+
+	wavHeaderSize = 44
+
+	//Sample rates outside of these bounds are taken as corrupted headers, they would stall readers sized after them
+	wavMinimumSampleRate = 8000
+	wavMaximumSampleRate = 384000
+	wavMaximumChannels   = 8
+)
+
+//WAVReader decodes the samples of a 16 bit PCM or 32 bit float WAV stream
+type WAVReader struct {
+	SampleRate int
+	Channels   int
+
+	data   io.Reader
+	format uint16
+	bits   uint16
+	buffer []byte
+}
+
+//NewWAVReader parses the header of a WAV stream up to the beginning of its samples
+func NewWAVReader(reader io.Reader) (*WAVReader, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("Not a WAV file")
+	}
+
+	wav := &WAVReader{}
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, errors.New("WAV file has no data chunk")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("Invalid WAV fmt chunk")
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return nil, err
+			}
+			wav.format = binary.LittleEndian.Uint16(body[0:])
+			wav.Channels = int(binary.LittleEndian.Uint16(body[2:]))
+			wav.SampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			wav.bits = binary.LittleEndian.Uint16(body[14:])
+			if wav.format == wavFormatExtensible && size >= 26 {
+				wav.format = binary.LittleEndian.Uint16(body[24:])
+			}
+		case "data":
+			if wav.format == 0 {
+				return nil, errors.New("WAV data chunk comes before fmt chunk")
+			}
+			if wav.Channels <= 0 || wav.Channels > wavMaximumChannels {
+				return nil, errors.New("WAV file has an invalid number of channels")
+			}
+			if wav.SampleRate < wavMinimumSampleRate || wav.SampleRate > wavMaximumSampleRate {
+				return nil, errors.New("WAV sample rate must be between 8000 and 384000 Hz")
+			}
+			if !(wav.format == wavFormatPCM && wav.bits == 16) && !(wav.format == wavFormatFloat && wav.bits == 32) {
+				return nil, errors.New("Only 16 bit PCM and 32 bit float WAV files are supported")
+			}
+			wav.data = io.LimitReader(reader, size)
+			return wav, nil
+		default:
+			if _, err := io.CopyN(io.Discard, reader, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+//Read decodes up to len(pcm) interleaved samples, it returns io.EOF once all samples were read
+func (wav *WAVReader) Read(pcm []float32) (int, error) {
+	sampleSize := int(wav.bits / 8)
+	if len(wav.buffer) < len(pcm)*sampleSize {
+		wav.buffer = make([]byte, len(pcm)*sampleSize)
+	}
+	n, err := io.ReadFull(wav.data, wav.buffer[:len(pcm)*sampleSize])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	samples := n / sampleSize
+	for i := 0; i < samples; i++ {
+		if wav.format == wavFormatFloat {
+			pcm[i] = math.Float32frombits(binary.LittleEndian.Uint32(wav.buffer[i*4:]))
+		} else {
+			pcm[i] = float32(int16(binary.LittleEndian.Uint16(wav.buffer[i*2:]))) / 32768
+		}
+	}
+	return samples, err
+}
+
+//WAVWriter encodes samples into a 16 bit PCM WAV stream
+type WAVWriter struct {
+	writer     io.WriteSeeker
+	sampleRate int
+	channels   int
+	size       uint32
+	buffer     []byte
+}
+
+//NewWAVWriter writes a WAV header to writer, the sizes in it are filled in by Close
+func NewWAVWriter(writer io.WriteSeeker, sampleRate, channels int) (*WAVWriter, error) {
+	wav := &WAVWriter{writer: writer, sampleRate: sampleRate, channels: channels}
+	if _, err := writer.Write(wav.header()); err != nil {
+		return nil, err
+	}
+	return wav, nil
+}
+
+func (wav *WAVWriter) header() []byte {
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], wavHeaderSize-8+wav.size)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(header[22:], uint16(wav.channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(wav.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(wav.sampleRate*wav.channels*2))
+	binary.LittleEndian.PutUint16(header[32:], uint16(wav.channels*2))
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], wav.size)
+	return header
+}
+
+//Write encodes interleaved samples, clipping them to [-1, 1]
+func (wav *WAVWriter) Write(pcm []float32) error {
+	if len(wav.buffer) < len(pcm)*2 {
+		wav.buffer = make([]byte, len(pcm)*2)
+	}
+	for i, v := range pcm {
+		v = float32(math.Max(-1, math.Min(1, float64(v))))
+		binary.LittleEndian.PutUint16(wav.buffer[i*2:], uint16(int16(v*32767)))
+	}
+	n, err := wav.writer.Write(wav.buffer[:len(pcm)*2])
+	wav.size += uint32(n)
+	return err
+}
+
+//Close rewrites the header with the final sizes
+func (wav *WAVWriter) Close() error {
+	if _, err := wav.writer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := wav.writer.Write(wav.header()); err != nil {
+		return err
+	}
+	_, err := wav.writer.Seek(0, io.SeekEnd)
+	return err
+}
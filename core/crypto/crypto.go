@@ -14,19 +14,22 @@ import (
 const NonceSize = chacha20poly1305.NonceSize
 
 //EncryptBytes creates an encrypted packet and consumes the plaintext storage
-func EncryptBytes(otherPk, userSk, plaintext []byte, packetID uint32) []byte {
+func EncryptBytes(otherPk, userSk, plaintext []byte, packetID uint32) ([]byte, error) {
 	id := uint32ToByte(packetID)
 
-	ckey, mkey := createTimeBaseKey(otherPk, userSk, 0)
+	ckey, mkey, err := createTimeBaseKey(otherPk, userSk, 0)
+	if err != nil {
+		return nil, err
+	}
 	nonce := mac512(mkey, id)[:NonceSize]
 
 	cipher, err := chacha20poly1305.New(ckey)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	ciphertext := cipher.Seal([]byte{}, nonce, plaintext, []byte{})
-	return append(id, ciphertext...)
+	return append(id, ciphertext...), nil
 }
 
 //DecryptBytes takes an encrypted packet and returns (packet id, plaintext)
@@ -38,10 +41,13 @@ func DecryptBytes(c, otherPk, userSk []byte) (uint32, []byte, error) {
 	reader.Read(packet)
 
 	for _, offset := range []int64{0, -1, 1} {
-		ckey, mkey := createTimeBaseKey(otherPk, userSk, offset)
+		ckey, mkey, err := createTimeBaseKey(otherPk, userSk, offset)
+		if err != nil {
+			return 0, nil, err
+		}
 		cipher, err := chacha20poly1305.New(ckey)
 		if err != nil {
-			panic(err)
+			return 0, nil, err
 		}
 		nonce := mac512(mkey, id)[:NonceSize]
 		if plaintext, err := cipher.Open([]byte{}, nonce, packet, []byte{}); err == nil {
@@ -51,19 +57,32 @@ func DecryptBytes(c, otherPk, userSk []byte) (uint32, []byte, error) {
 	return 0, nil, errors.New("Unable to decrypt messsage")
 }
 
-func createTimeBaseKey(otherPk, userSk []byte, offset int64) ([]byte, []byte) {
-	seed := createKeySeed(otherPk, userSk)
+//CheckKeys tells whether a shared secret can be created from a peer's public key and the user's secret key,
+//X25519 rejecting keys of the wrong size and public keys of small order
+func CheckKeys(otherPk, userSk []byte) error {
+	_, err := createKeySeed(otherPk, userSk)
+	return err
+}
+
+func createTimeBaseKey(otherPk, userSk []byte, offset int64) ([]byte, []byte, error) {
+	seed, err := createKeySeed(otherPk, userSk)
+	if err != nil {
+		return nil, nil, err
+	}
 	value := make([]byte, 8)
 	binary.LittleEndian.PutUint64(value, uint64(time.Now().UTC().Unix()/30+offset))
 	key := mac512(seed, value)
-	return key[0:32], key[32:64]
+	return key[0:32], key[32:64], nil
 }
 
-func createKeySeed(otherPk, userSk []byte) []byte {
-	userPk := CreatePublicKey(userSk)
+func createKeySeed(otherPk, userSk []byte) ([]byte, error) {
+	userPk, err := CreatePublicKey(userSk)
+	if err != nil {
+		return nil, err
+	}
 	secret, err := curve25519.X25519(userSk, otherPk)
 	if err != nil {
-		panic("Key exchanged failed")
+		return nil, errors.New("Key exchange failed: " + err.Error())
 	}
 
 	var pkconcat []byte
@@ -74,5 +93,5 @@ func createKeySeed(otherPk, userSk []byte) []byte {
 	}
 
 	secret = hash512(append(secret, pkconcat...))
-	return secret
+	return secret, nil
 }
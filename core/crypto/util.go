@@ -3,18 +3,19 @@ package crypto
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/curve25519"
 )
 
 //CreatePublicKey generates a public key give a secret key sk
-func CreatePublicKey(sk []byte) []byte {
+func CreatePublicKey(sk []byte) ([]byte, error) {
 	pk, err := curve25519.X25519(sk, curve25519.Basepoint)
 	if err != nil {
-		panic("Unable to create public key")
+		return nil, errors.New("Unable to create public key: " + err.Error())
 	}
-	return pk
+	return pk, nil
 }
 
 //RandomBytes return a []byte of n size with random content
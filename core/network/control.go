@@ -0,0 +1,122 @@
+package network
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+)
+
+//List of control message ids, carried right after ControlID
+const (
+	ControlPing         = 0
+	ControlPong         = 1
+	ControlComfortNoise = 2
+	ControlMute         = 3
+	ControlFormat       = 4
+	ControlRecording    = 5
+	ControlKeyframe     = 6
+)
+
+//formatDurationUnit is the unit of the frame duration in a ControlFormat message
+const formatDurationUnit = time.Microsecond * 100
+
+//reportInterval is how often peers are pinged and sent reports on their audio
+const reportInterval = time.Second * 2
+
+func (peer *Peer) receiveControlPacket(packet *Packet) {
+	if len(packet.RawData) < 2 {
+		return
+	}
+	body := packet.RawData[2:]
+	switch packet.RawData[1] {
+	case ControlPing:
+		if len(body) == 8 {
+			peer.sendControlPacket(ControlPong, body)
+		}
+	case ControlPong:
+		if len(body) == 8 {
+			sent := int64(binary.LittleEndian.Uint64(body))
+			rtt := time.Duration(time.Now().UnixNano() - sent)
+			peer.statistics.update(func(stats *Statistics) {
+				stats.RTT = rtt
+			})
+		}
+	case ControlComfortNoise:
+		if len(body) == 2 {
+			peer.reception.pause()
+			peer.pauseAudio()
+			peer.receiveComfortNoise(float32(binary.LittleEndian.Uint16(body)) / math.MaxUint16)
+		}
+	case ControlMute:
+		if len(body) == 1 {
+			peer.setRemoteMuted(body[0] != 0)
+		}
+	case ControlRecording:
+		if len(body) == 1 {
+			peer.setRemoteRecording(body[0] != 0)
+		}
+	case ControlKeyframe:
+		peer.receiveKeyframeRequest()
+	case ControlFormat:
+		if len(body) == 3 {
+			//The duration sizes the buffers of lost frames, so only durations Opus encodes are accepted
+			duration := time.Duration(binary.LittleEndian.Uint16(body)) * formatDurationUnit
+			switch duration {
+			case time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40, time.Millisecond * 60:
+				peer.setRemoteFrameDuration(duration)
+			}
+			if channels := int(body[2]); channels == 1 || channels == 2 {
+				peer.setRemoteChannels(channels)
+			}
+		}
+	default:
+		log.Printf("Unsupported control message %d\n", packet.RawData[1])
+	}
+}
+
+func (peer *Peer) sendControlPacket(id byte, body []byte) {
+	peer.sendPacket(append([]byte{ControlID, id}, body...))
+}
+
+func (peer *Peer) sendPing() {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, uint64(time.Now().UnixNano()))
+	peer.sendControlPacket(ControlPing, body)
+}
+
+func (peer *Peer) sendComfortNoise(level float32) {
+	if level > 1 {
+		level = 1
+	}
+	body := make([]byte, 2)
+	binary.LittleEndian.PutUint16(body, uint16(level*math.MaxUint16))
+	peer.sendControlPacket(ControlComfortNoise, body)
+}
+
+func (peer *Peer) sendMute(muted bool) {
+	body := []byte{0}
+	if muted {
+		body[0] = 1
+	}
+	peer.sendControlPacket(ControlMute, body)
+}
+
+func (peer *Peer) sendRecording(recording bool) {
+	body := []byte{0}
+	if recording {
+		body[0] = 1
+	}
+	peer.sendControlPacket(ControlRecording, body)
+}
+
+//sendFormat tells the peer how our audio is encoded, as Opus packets do not carry it in a form we can read
+func (peer *Peer) sendFormat() {
+	format := audio.CurrentFormat()
+	body := make([]byte, 3)
+	binary.LittleEndian.PutUint16(body, uint16(format.FrameDuration/formatDurationUnit))
+	body[2] = byte(format.Channels)
+	peer.sendControlPacket(ControlFormat, body)
+}
@@ -4,9 +4,13 @@ import (
 	"errors"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hexdiract/spear/core/audio"
 	"github.com/hexdiract/spear/core/crypto"
+	"github.com/hexdiract/spear/core/video"
 )
 
 //DeterminableAddr is a container of candidates and the current one
@@ -21,14 +25,59 @@ type Client struct {
 
 	PeerList []*Peer
 
+	//Mixer combines the audio of peers for playback, created by Initialize
+	Mixer *audio.Mixer
+	//EchoCanceller removes the played back audio from the captured one, nil disables it
+	EchoCanceller *audio.EchoCanceller
+	//Preprocessing is run on captured audio after echo cancellation
+	Preprocessing audio.Chain
+	//Injector plays clips into the captured audio after preprocessing, created by Initialize
+	Injector *audio.Injector
+	//RecordingOptions is used by StartRecording
+	RecordingOptions RecordingOptions
+
 	Addr DeterminableAddr
 	conn *net.UDPConn
+
+	encoders     map[*Peer]*groupEncoder
+	vad          *audio.VAD
+	silentFrames int
+	muted        int32
+
+	levelMutex   sync.Mutex
+	localLevel   audio.Level
+	transmitting bool
+
+	recordingMutex sync.Mutex
+	recording      *recording
+
+	screencastMutex sync.Mutex
+	//screencastStop is closed to stop the screencast, nil when the screen is not sent
+	screencastStop chan struct{}
+	//screencastDone is closed once the screencast goroutine returned
+	screencastDone chan struct{}
+	captureMutex   sync.Mutex
+	captureOptions *video.CaptureOptions
+	//source replaces the screen as the source of screencast frames when set
+	source video.Source
+	screen *video.ScreenSource
+	//keyframeRequested is set when a peer lost frames of our screencast
+	keyframeRequested int32
+
+	//closed is closed by Close to stop the network goroutines, stopped waits for them to return
+	closed  chan struct{}
+	stopped sync.WaitGroup
 }
 
 //Initialize setup the client, should be called first
 func (client *Client) Initialize() error {
 	if len(client.Addr.Candidates) == 0 {
-		panic("Address candidates is empty")
+		return errors.New("Address candidates is empty")
+	}
+	for _, peer := range client.PeerList {
+		if err := crypto.CheckKeys(peer.PublicKey, client.SecretKey); err != nil {
+			return errors.New("Invalid key of peer " + peer.DisplayName() + ": " + err.Error())
+		}
 	}
 	conn, err := client.bind()
 	if err != nil {
@@ -36,26 +85,65 @@ func (client *Client) Initialize() error {
 	}
 	conn.SetReadBuffer(0x100000)
 	client.conn = conn
+	client.vad = audio.NewVAD()
+	client.Mixer = audio.NewMixer()
+	client.Injector = audio.NewInjector()
 	for _, p := range client.PeerList {
-		p.init(client)
+		if err := p.init(client); err != nil {
+			conn.Close()
+			return err
+		}
+		p.sendFormat()
 	}
 
+	client.closed = make(chan struct{})
+	client.stopped.Add(2)
 	go client.start()
+	go client.maintain()
 	return nil
 }
 
+//Close stops sharing the screen and recording, stops the goroutines of the client and of its peers,
+//and closes its socket and video source. The client cannot be used afterwards
+func (client *Client) Close() error {
+	client.StopScreencast()
+	client.StopRecording()
+	close(client.closed)
+	err := client.conn.Close()
+	client.stopped.Wait()
+	//Nothing is received anymore, so peers can stop decoding
+	for _, peer := range client.PeerList {
+		peer.stop()
+	}
+
+	client.SetVideoSource(nil)
+	client.captureMutex.Lock()
+	defer client.captureMutex.Unlock()
+	if client.screen != nil {
+		client.screen.Close()
+		client.screen = nil
+	}
+	return err
+}
+
 func (client *Client) start() {
+	defer client.stopped.Done()
 	for {
 		buffer := make([]byte, 0x1000)
 		size, addr, err := client.conn.ReadFromUDP(buffer)
 		if err != nil {
+			select {
+			case <-client.closed:
+				return
+			default:
+			}
 			log.Println(err)
 			continue
 		}
 
 		for _, peer := range client.getPeerByAddr(addr) {
 			id, plaintext, err := crypto.DecryptBytes(buffer[:size], peer.PublicKey, client.SecretKey)
-			if err == nil {
+			if err == nil && len(plaintext) > 0 {
 				packet := &Packet{
 					ID:           id,
 					RawData:      plaintext,
@@ -65,6 +153,12 @@ func (client *Client) start() {
 				switch plaintext[0] {
 				case AudioID:
 					peer.receiveAudioPacket(packet)
+				case VideoID:
+					peer.receiveVideoPacket(packet)
+				case ControlID:
+					peer.receiveControlPacket(packet)
+				case ReportID:
+					peer.receiveReport(packet)
 				default:
 					log.Printf("Unsupported data %d\n", plaintext[0])
 				}
@@ -75,6 +169,45 @@ func (client *Client) start() {
 	}
 }
 
+func (client *Client) maintain() {
+	defer client.stopped.Done()
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.closed:
+			return
+		case <-ticker.C:
+		}
+		for _, peer := range client.PeerList {
+			peer.sendPing()
+			peer.sendReport()
+			peer.sendMute(client.Muted())
+			peer.sendRecording(client.Recording())
+			peer.sendFormat()
+		}
+	}
+}
+
+//SetMuted gates the outgoing audio and lets every peer know about it
+func (client *Client) SetMuted(muted bool) {
+	var value int32
+	if muted {
+		value = 1
+	}
+	if atomic.SwapInt32(&client.muted, value) == value {
+		return
+	}
+	for _, peer := range client.PeerList {
+		peer.sendMute(muted)
+	}
+}
+
+//Muted tells whether the outgoing audio is muted
+func (client *Client) Muted() bool {
+	return atomic.LoadInt32(&client.muted) != 0
+}
+
 func (client *Client) getPeerByAddr(addr *net.UDPAddr) []*Peer {
 	peers := []*Peer{}
 	for _, peer := range client.PeerList {
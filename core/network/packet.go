@@ -1,14 +1,26 @@
 package network
 
 import (
+	"encoding/binary"
+	"errors"
 	"math"
+	"sync"
 	"time"
 )
 
 //List of packet ids
 const (
-	AudioID = 0
-	VideoID = 1
+	AudioID   = 0
+	VideoID   = 1
+	ControlID = 2
+	ReportID  = 3
+)
+
+//Packet ids double as nonces, so each stream numbers its packets from its own base
+const (
+	audioIDBase   = 0
+	videoIDBase   = 1 << 30
+	controlIDBase = 1 << 31
 )
 
 //Packet refers to a decrypted incoming packet sent by a peer
@@ -18,14 +30,60 @@ type Packet struct {
 	ReceivedTime int64
 }
 
+//Report refers to a receiver's summary of the audio stream it gets from a peer
+type Report struct {
+	//Received is the number of packets received since the stream started
+	Received uint32
+	//Loss is the fraction of packets lost since the previous report
+	Loss      float32
+	Jitter    time.Duration
+	HighestID uint32
+}
+
+const reportSize = 11
+
+//Encode serializes the report into the body of a ReportID packet
+func (report *Report) Encode() []byte {
+	jitter := report.Jitter / time.Millisecond
+	if jitter > math.MaxUint16 {
+		jitter = math.MaxUint16
+	}
+	body := make([]byte, reportSize)
+	binary.LittleEndian.PutUint32(body[0:], report.Received)
+	body[4] = byte(report.Loss * 255)
+	binary.LittleEndian.PutUint16(body[5:], uint16(jitter))
+	binary.LittleEndian.PutUint32(body[7:], report.HighestID)
+	return body
+}
+
+//DecodeReport parses the body of a ReportID packet
+func DecodeReport(body []byte) (*Report, error) {
+	if len(body) != reportSize {
+		return nil, errors.New("Invalid report size")
+	}
+	return &Report{
+		Received:  binary.LittleEndian.Uint32(body[0:]),
+		Loss:      float32(body[4]) / 255,
+		Jitter:    time.Duration(binary.LittleEndian.Uint16(body[5:])) * time.Millisecond,
+		HighestID: binary.LittleEndian.Uint32(body[7:]),
+	}, nil
+}
+
 const minimumBufferSize = 3
 const maximumFailedPacket = 20
 const maximumTimeDifference = 1500
 
-//PacketBuffer is a queue of packet, handles packet ordering internally
+//PacketBuffer is a queue of packet, handles packet ordering internally.
+//Packets are pushed by the network goroutine and popped by the audio one
 type PacketBuffer struct {
-	idToPacket            map[uint32]*Packet
-	currentID             uint32
+	mutex sync.Mutex
+	//idToPacket holds the packets waiting to be popped
+	idToPacket map[uint32]*Packet
+	currentID  uint32
+	//playing is set once enough packets were buffered, packets are then popped as long as some are waiting
+	playing bool
+	//resync makes the next packet the start of the stream if the buffer ran empty
+	resync                bool
 	rejectedPacketCounter uint
 }
 
@@ -43,19 +101,30 @@ func (buffer *PacketBuffer) reset() {
 	buffer.idToPacket = map[uint32]*Packet{}
 	buffer.rejectedPacketCounter = 0
 	buffer.currentID = 0
+	buffer.playing = false
+	buffer.resync = false
 }
 
 //Push a packet to the buffer
 func (buffer *PacketBuffer) Push(packet *Packet) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
 	if buffer.idToPacket == nil || buffer.rejectedPacketCounter > maximumFailedPacket {
 		buffer.reset()
 	}
+	//Packets still waiting when the peer resumes mean that the pause was too short to matter
+	if buffer.resync {
+		if len(buffer.idToPacket) == 0 {
+			buffer.reset()
+		}
+		buffer.resync = false
+	}
 
 	//Check for outdated packets
 	now := time.Now().UnixNano() / 1000000
 	outdatedPackets := []uint32{}
 	for k, v := range buffer.idToPacket {
-		if now-v.ReceivedTime > maximumTimeDifference || v.ID < buffer.currentID {
+		if now-v.ReceivedTime > maximumTimeDifference {
 			outdatedPackets = append(outdatedPackets, k)
 		}
 	}
@@ -71,19 +140,63 @@ func (buffer *PacketBuffer) Push(packet *Packet) {
 	}
 }
 
-//Pop a packet from the buffer
+//Pop a packet from the buffer, nil standing for a lost packet or an empty buffer
 func (buffer *PacketBuffer) Pop() *Packet {
-	if len(buffer.idToPacket) < minimumBufferSize {
-		return nil
-	}
-	if buffer.currentID == 0 {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if !buffer.playing {
+		if len(buffer.idToPacket) < minimumBufferSize {
+			return nil
+		}
+		buffer.playing = true
 		buffer.currentID = buffer.findSmallestPacket()
 	}
-	if packet, ok := buffer.idToPacket[buffer.currentID]; ok {
-		buffer.currentID++
+	//The peer sends nothing while it is silent, which is no reason to count packets as lost
+	if len(buffer.idToPacket) == 0 {
+		return nil
+	}
+	packet, ok := buffer.idToPacket[buffer.currentID]
+	buffer.currentID++
+	if ok {
+		delete(buffer.idToPacket, packet.ID)
 		buffer.rejectedPacketCounter = 0
 		return packet
 	}
-	buffer.currentID++
 	return nil
 }
+
+//Ready tells whether Pop has packets to return, either because enough were buffered
+//or because the buffer is playing and some are waiting
+func (buffer *PacketBuffer) Ready() bool {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if buffer.playing {
+		return len(buffer.idToPacket) > 0
+	}
+	return len(buffer.idToPacket) >= minimumBufferSize
+}
+
+//Len returns the number of packets waiting in the buffer
+func (buffer *PacketBuffer) Len() int {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return len(buffer.idToPacket)
+}
+
+//Peek returns the packet that the next Pop would return without removing it
+func (buffer *PacketBuffer) Peek() *Packet {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if !buffer.playing {
+		return nil
+	}
+	return buffer.idToPacket[buffer.currentID]
+}
+
+//Resync is called when the peer paused sending, the first packet after the pause starts the stream over
+//so that a peer that restarted its numbering is not rejected
+func (buffer *PacketBuffer) Resync() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.resync = true
+}
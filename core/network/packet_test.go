@@ -0,0 +1,91 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+//talk pushes packets numbered from first to last, popping one packet per frame as the audio loop does,
+//and returns the ids of the packets popped. Frames are played until the buffer is drained
+func talk(buffer *PacketBuffer, first, last uint32) []uint32 {
+	var popped []uint32
+	pop := func() {
+		if !buffer.Ready() {
+			return
+		}
+		if packet := buffer.Pop(); packet != nil {
+			popped = append(popped, packet.ID)
+		}
+	}
+	for id := first; id <= last; id++ {
+		buffer.Push(&Packet{ID: id, ReceivedTime: time.Now().UnixNano() / 1000000})
+		pop()
+	}
+	for buffer.Len() > 0 {
+		pop()
+	}
+	return popped
+}
+
+//silence plays frames while the peer sends nothing
+func silence(t *testing.T, buffer *PacketBuffer, frames int) {
+	for i := 0; i < frames; i++ {
+		if buffer.Ready() {
+			t.Fatal("Buffer is ready while nothing was received")
+		}
+		if packet := buffer.Pop(); packet != nil {
+			t.Fatalf("Popped packet %d while nothing was received", packet.ID)
+		}
+	}
+}
+
+func checkTalk(t *testing.T, popped []uint32, first, last uint32) {
+	if len(popped) != int(last-first+1) {
+		t.Fatalf("Popped %d packets, want %d", len(popped), last-first+1)
+	}
+	for i, id := range popped {
+		if id != first+uint32(i) {
+			t.Fatalf("Popped packet %d in place of %d", id, first+uint32(i))
+		}
+	}
+}
+
+func TestPacketBufferSilence(t *testing.T) {
+	buffer := &PacketBuffer{}
+	checkTalk(t, talk(buffer, 0, 9), 0, 9)
+
+	//The peer goes silent and lets us know, then talks again with the ids following the last packet
+	buffer.Resync()
+	silence(t, buffer, 50)
+	checkTalk(t, talk(buffer, 10, 39), 10, 39)
+
+	//Silence without an indication, as when it is lost
+	silence(t, buffer, 50)
+	checkTalk(t, talk(buffer, 40, 69), 40, 69)
+}
+
+func TestPacketBufferRestart(t *testing.T) {
+	buffer := &PacketBuffer{}
+	checkTalk(t, talk(buffer, 100, 129), 100, 129)
+
+	//The peer restarted and numbers its packets from the beginning again
+	buffer.Resync()
+	silence(t, buffer, 10)
+	checkTalk(t, talk(buffer, 0, 9), 0, 9)
+}
+
+func TestPacketBufferLoss(t *testing.T) {
+	buffer := &PacketBuffer{}
+	for _, id := range []uint32{0, 1, 2, 4} {
+		buffer.Push(&Packet{ID: id, ReceivedTime: time.Now().UnixNano() / 1000000})
+	}
+	for _, want := range []int{0, 1, 2, -1, 4} {
+		packet := buffer.Pop()
+		if want < 0 && packet != nil || want >= 0 && (packet == nil || packet.ID != uint32(want)) {
+			t.Fatalf("Popped %v, want packet %d", packet, want)
+		}
+	}
+	if buffer.Ready() || buffer.Len() != 0 {
+		t.Fatal("Buffer is not empty after popping every packet")
+	}
+}
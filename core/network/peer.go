@@ -2,6 +2,11 @@ package network
 
 import (
 	"encoding/base64"
+	"image"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hexdiract/spear/core/audio"
@@ -13,38 +18,190 @@ type Peer struct {
 	PublicKey []byte
 	Addr      DeterminableAddr
 	Volume    float32
-	Name      string
+	//Pan places the peer between the left (-1) and right (1) speaker when playing in stereo
+	Pan  float32
+	Name string
 
-	lastPacketReceived int64
-	receiveAudioPacket func(*Packet)
-	GetAudioData       func() []float32
-	SendOpusData       func([]byte)
+	lastPacketReceived  int64
+	remoteMuted         int32
+	remoteRecording     int32
+	remoteFrameDuration int64
+	remoteChannels      int32
+	playbackSpeed       uint64
+	lastVideoFrame      int64
+	lastKeyframeRequest int64
+	reception           receptionStats
+	statistics          peerStatistics
+	controller          *audio.Controller
+	receiveAudioPacket  func(*Packet)
+	receiveComfortNoise func(float32)
+	//pauseAudio is called when the peer stops sending audio
+	pauseAudio         func()
+	receiveVideoPacket func(*Packet)
+	//receiveKeyframeRequest makes the next frame of our screencast a keyframe
+	receiveKeyframeRequest func()
+	sendPacket             func([]byte)
+	sendVideoPacket        func([]byte)
+	//stop ends the goroutines of the peer, once nothing is received from it anymore
+	stop         func()
+	GetAudioData func() []float32
+	SendOpusData func([]byte)
+
+	videoMutex    sync.Mutex
+	videoCallback func(image.Image)
 }
 
-func (peer *Peer) init(client *Client) {
+func (peer *Peer) init(client *Client) error {
 	audioBuffer := PacketBuffer{}
-	opusDecoder := audio.NewDecoder()
-	audioPacketID := uint32(0)
+	opusDecoder, err := audio.NewDecoder()
+	if err != nil {
+		return err
+	}
+	audioPacketID := uint32(audioIDBase)
+	videoPacketID := uint32(videoIDBase)
+	controlPacketID := uint32(controlIDBase)
 
 	peer.Volume = 1
+	peer.controller = audio.NewController()
 
-	peer.receiveAudioPacket = audioBuffer.Push
-	peer.GetAudioData = func() []float32 {
-		var content []byte = nil
+	//Comfort noise is played in place of missing audio while the peer is silent
+	var noiseMutex sync.Mutex
+	var noiseLevel float32
+	var noiseReceived time.Time
+
+	peer.receiveAudioPacket = func(packet *Packet) {
+		peer.reception.record(packet)
+		audioBuffer.Push(packet)
+	}
+	peer.pauseAudio = audioBuffer.Resync
+	peer.receiveComfortNoise = func(level float32) {
+		noiseMutex.Lock()
+		defer noiseMutex.Unlock()
+		noiseLevel = level
+		noiseReceived = time.Now()
+	}
+
+	//Complete frames wait here while the previous one is decoded
+	assembler := newFrameAssembler()
+	videoFrames := make(chan videoFrame, 1)
+	go peer.decodeVideo(videoFrames)
+	peer.stop = func() {
+		close(videoFrames)
+	}
+	//skipped tells whether frames were lost since the last one passed to the decoder
+	var skipped bool
+
+	peer.receiveVideoPacket = func(packet *Packet) {
+		number, index, count, fragment, ok := parseVideoPacket(packet.RawData)
+		if !ok {
+			return
+		}
+		frame, lost := assembler.add(number, index, count, fragment, time.Now())
+		skipped = skipped || lost
+		if frame != nil {
+			select {
+			case videoFrames <- videoFrame{data: frame, afterLoss: skipped}:
+				skipped = false
+			default:
+				//The decoder is behind, and the next frames depend on the dropped one
+				skipped, lost = true, true
+			}
+		}
+		if lost {
+			peer.requestKeyframe()
+		}
+	}
+	peer.receiveKeyframeRequest = client.requestKeyframe
+
+	decode := func() []float32 {
+		if !audioBuffer.Ready() {
+			return nil
+		}
+		//Lost or undecodable packets are replaced with FEC data or silence to keep the timing
+		frameSize := int(audio.SampleRate * peer.RemoteFrameDuration() / time.Second)
 		if packet := audioBuffer.Pop(); packet != nil {
-			content = packet.RawData[1:]
+			peer.receiveComfortNoise(0)
+			client.recordPeer(peer, packet.RawData[1:], frameSize)
+			if data, err := audio.DecompressAudio(opusDecoder, packet.RawData[1:]); err == nil {
+				return data
+			}
+		} else {
+			client.recordPeer(peer, nil, frameSize)
+			if next := audioBuffer.Peek(); next != nil {
+				if data, err := audio.RecoverAudio(opusDecoder, next.RawData[1:], frameSize); err == nil {
+					return data
+				}
+			}
 		}
-		data, err := audio.DecompressAudio(opusDecoder, content)
-		if err != nil {
+		return make([]float32, frameSize*audio.Channels())
+	}
+
+	//Decoded samples waiting to be played, as the peer's frames may be shorter or longer than ours
+	var pending []float32
+	drift := audio.NewDriftCompensator()
+
+	peer.GetAudioData = func() []float32 {
+		size := audio.FrameSize() * audio.Channels()
+		if audioBuffer.Ready() {
+			frameSize := int(audio.SampleRate * peer.RemoteFrameDuration() / time.Second)
+			//Only packets waiting to be played are queued, popped ones are gone from the buffer
+			drift.Measure(audioBuffer.Len()*frameSize + len(pending)/audio.Channels())
+			atomic.StoreUint64(&peer.playbackSpeed, math.Float64bits(drift.Speed()))
+		}
+		for len(pending) < size {
+			data := decode()
+			if data == nil {
+				break
+			}
+			pending = append(pending, drift.Process(data)...)
+		}
+
+		if len(pending) < size {
+			noiseMutex.Lock()
+			level, received := noiseLevel, noiseReceived
+			noiseMutex.Unlock()
+			if level > 0 && time.Since(received) < comfortNoiseTimeout {
+				return audio.ComfortNoise(level)
+			}
 			return nil
 		}
-		return data
+
+		frame := make([]float32, size)
+		copy(frame, pending)
+		pending = append(pending[:0], pending[size:]...)
+		return frame
 	}
 	peer.SendOpusData = func(data []byte) {
-		ciphertext := crypto.EncryptBytes(peer.PublicKey, client.SecretKey, append([]byte{0}, data...), audioPacketID)
+		ciphertext, err := crypto.EncryptBytes(peer.PublicKey, client.SecretKey, append([]byte{AudioID}, data...), nextID(&audioPacketID))
+		if err != nil {
+			log.Println("Unable to encrypt audio for " + peer.DisplayName() + ": " + err.Error())
+			return
+		}
+		client.writeTo(peer, ciphertext)
+	}
+	peer.sendVideoPacket = func(plaintext []byte) {
+		ciphertext, err := crypto.EncryptBytes(peer.PublicKey, client.SecretKey, plaintext, nextID(&videoPacketID))
+		if err != nil {
+			log.Println("Unable to encrypt video for " + peer.DisplayName() + ": " + err.Error())
+			return
+		}
 		client.writeTo(peer, ciphertext)
-		audioPacketID++
 	}
+	peer.sendPacket = func(plaintext []byte) {
+		ciphertext, err := crypto.EncryptBytes(peer.PublicKey, client.SecretKey, plaintext, nextID(&controlPacketID))
+		if err != nil {
+			log.Println("Unable to encrypt packet for " + peer.DisplayName() + ": " + err.Error())
+			return
+		}
+		client.writeTo(peer, ciphertext)
+	}
+	return nil
+}
+
+//nextID takes the next packet id of a stream. Ids are nonces, so each one must be used only once
+//even when packets of the stream are sent from several goroutines
+func nextID(id *uint32) uint32 {
+	return atomic.AddUint32(id, 1) - 1
 }
 
 //Status returns connection status from a peer
@@ -55,6 +212,70 @@ func (peer *Peer) Status() string {
 	return "Connected"
 }
 
+//Muted tells whether the peer has muted its microphone
+func (peer *Peer) Muted() bool {
+	return atomic.LoadInt32(&peer.remoteMuted) != 0
+}
+
+func (peer *Peer) setRemoteMuted(muted bool) {
+	var value int32
+	if muted {
+		value = 1
+	}
+	atomic.StoreInt32(&peer.remoteMuted, value)
+}
+
+//Recording tells whether the peer is recording the call
+func (peer *Peer) Recording() bool {
+	return atomic.LoadInt32(&peer.remoteRecording) != 0
+}
+
+func (peer *Peer) setRemoteRecording(recording bool) {
+	var value int32
+	if recording {
+		value = 1
+	}
+	atomic.StoreInt32(&peer.remoteRecording, value)
+}
+
+//RemoteFrameDuration returns the frame duration the peer encodes its audio with
+func (peer *Peer) RemoteFrameDuration() time.Duration {
+	if duration := atomic.LoadInt64(&peer.remoteFrameDuration); duration > 0 {
+		return time.Duration(duration)
+	}
+	return audio.DefaultFormat.FrameDuration
+}
+
+func (peer *Peer) setRemoteFrameDuration(duration time.Duration) {
+	atomic.StoreInt64(&peer.remoteFrameDuration, int64(duration))
+	peer.reception.setFrameDuration(duration)
+}
+
+//RemoteChannels returns the number of channels the peer encodes its audio with
+func (peer *Peer) RemoteChannels() int {
+	if channels := atomic.LoadInt32(&peer.remoteChannels); channels > 0 {
+		return int(channels)
+	}
+	return audio.DefaultFormat.Channels
+}
+
+func (peer *Peer) setRemoteChannels(channels int) {
+	atomic.StoreInt32(&peer.remoteChannels, int32(channels))
+}
+
+//PlaybackSpeed returns how fast the peer's audio is played to compensate for the drift between sound card clocks
+func (peer *Peer) PlaybackSpeed() float64 {
+	if speed := math.Float64frombits(atomic.LoadUint64(&peer.playbackSpeed)); speed > 0 {
+		return speed
+	}
+	return 1
+}
+
+//EncoderSettings returns the encoder settings adapted to the peer's network conditions
+func (peer *Peer) EncoderSettings() audio.Settings {
+	return peer.controller.Settings()
+}
+
 //DisplayName returns the displayed name on CUI
 func (peer *Peer) DisplayName() string {
 	if len(peer.Name) == 0 {
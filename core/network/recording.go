@@ -0,0 +1,289 @@
+package network
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+	"gopkg.in/hraban/opus.v2"
+)
+
+//recordingLag is how far a track may fall behind the call before the gap is filled,
+//it leaves room for peers whose frames are longer than ours
+const recordingLag = time.Millisecond * 120
+
+//RecordingOptions tells where and how calls are recorded
+type RecordingOptions struct {
+	//Directory receives the recordings, named after the time they started
+	Directory string
+	//Mixed records the microphone and every peer into a single re-encoded file,
+	//instead of one file per participant holding the Opus packets as they were sent
+	Mixed bool
+}
+
+//track is one Ogg Opus file of a recording
+type track struct {
+	file   *os.File
+	writer *audio.OggWriter
+	//err is the first write error, the track is not written to afterwards
+	err error
+}
+
+//createTrack creates a file of channels channels, the packets written to it being decoded as such
+func createTrack(path string, channels int) (*track, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := audio.NewOggWriter(file, channels)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &track{file: file, writer: writer}, nil
+}
+
+func (track *track) write(data []byte) {
+	if track.err == nil {
+		track.check(track.writer.WritePacket(data))
+	}
+}
+
+func (track *track) skip(samples int) {
+	if track.err == nil {
+		track.check(track.writer.Skip(samples))
+	}
+}
+
+func (track *track) check(err error) {
+	if err != nil {
+		track.err = err
+		log.Println("Unable to write " + track.file.Name() + ": " + err.Error())
+	}
+}
+
+func (track *track) close() error {
+	err := track.writer.Close()
+	if closeErr := track.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//recording writes the call to Ogg Opus files, it is fed by the audio loop
+type recording struct {
+	mutex  sync.Mutex
+	closed bool
+	mixed  bool
+	//position is the number of samples per channel since the recording started
+	position int64
+
+	//local holds the microphone, or the whole call when mixed
+	local *track
+	peers map[*Peer]*track
+
+	//encoder compresses what was not already encoded for peers
+	encoder *opus.Encoder
+	mixer   *audio.Mixer
+	mix     []float32
+}
+
+func newRecording(options RecordingOptions, peers []*Peer) (*recording, error) {
+	encoder, err := audio.NewEncoder()
+	if err != nil {
+		return nil, err
+	}
+	format := audio.CurrentFormat()
+	if err := (audio.Settings{Bitrate: format.Bitrate, Complexity: format.Complexity}).Apply(encoder); err != nil {
+		return nil, err
+	}
+	recording := &recording{
+		mixed:   options.Mixed,
+		peers:   map[*Peer]*track{},
+		encoder: encoder,
+		mixer:   audio.NewMixer(),
+		mix:     make([]float32, audio.FrameSize()*audio.Channels()),
+	}
+
+	prefix := filepath.Join(options.Directory, time.Now().Format("2006-01-02_15-04-05"))
+	if options.Mixed {
+		local, err := createTrack(prefix+".opus", audio.Channels())
+		if err != nil {
+			return nil, err
+		}
+		recording.local = local
+		return recording, nil
+	}
+
+	local, err := createTrack(prefix+"-me.opus", audio.Channels())
+	if err != nil {
+		return nil, err
+	}
+	recording.local = local
+	for i, peer := range peers {
+		//Peer tracks hold the packets as the peer encoded them. The index keeps names
+		//that only differ by unsafe characters, such as "Friend 1" and "Friend_1", from sharing a file
+		name := strconv.Itoa(i+1) + "-" + fileName(peer.DisplayName())
+		track, err := createTrack(prefix+"-"+name+".opus", peer.RemoteChannels())
+		if err != nil {
+			recording.close()
+			return nil, err
+		}
+		recording.peers[peer] = track
+	}
+	return recording, nil
+}
+
+//fileName replaces the characters of a peer name that are unsafe in file names
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+//writeLocal records a captured frame, data being its encoding for peers or nil if it was not encoded
+func (recording *recording) writeLocal(pcm []float32, data []byte) {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	if recording.closed || recording.mixed {
+		return
+	}
+	if data == nil {
+		var err error
+		if data, err = audio.CompressAudio(recording.encoder, pcm); err != nil {
+			log.Println("Unable to encode recorded audio: " + err.Error())
+			return
+		}
+	}
+	recording.local.write(data)
+}
+
+//writePeer records a packet received from a peer, or a lost frame of samples samples if data is nil
+func (recording *recording) writePeer(peer *Peer, data []byte, samples int) {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	if recording.closed || recording.mixed {
+		return
+	}
+	if track, ok := recording.peers[peer]; ok {
+		if data == nil {
+			track.skip(samples)
+		} else {
+			track.write(data)
+		}
+	}
+}
+
+//advance ends a frame of the call, in being the captured frame or nil if it was not sent and out the playback.
+//The mix is encoded when mixing, otherwise tracks that received nothing for a while are filled with silence
+func (recording *recording) advance(in []float32, out []float32) {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	if recording.closed {
+		return
+	}
+
+	if recording.mixed {
+		recording.mixer.Add(audio.Source{Key: "microphone", PCM: in, Gain: 1})
+		recording.mixer.Add(audio.Source{Key: "playback", PCM: out, Gain: 1})
+		recording.mixer.Mix(recording.mix)
+		if data, err := audio.CompressAudio(recording.encoder, recording.mix); err == nil {
+			recording.local.write(data)
+		} else {
+			log.Println("Unable to encode recorded audio: " + err.Error())
+		}
+		return
+	}
+
+	recording.position += int64(audio.FrameSize())
+	lag := int64(audio.SampleRate * recordingLag / time.Second)
+	for _, track := range recording.tracks() {
+		if gap := recording.position - track.writer.Samples(); gap > lag {
+			track.skip(int(gap))
+		}
+	}
+}
+
+//tracks returns the local track followed by those of peers
+func (recording *recording) tracks() []*track {
+	tracks := []*track{recording.local}
+	for _, track := range recording.peers {
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+func (recording *recording) close() error {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	recording.closed = true
+
+	var err error
+	for _, track := range recording.tracks() {
+		if closeErr := track.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//StartRecording starts writing the call to files as set by RecordingOptions, and lets every peer know
+func (client *Client) StartRecording() error {
+	client.recordingMutex.Lock()
+	defer client.recordingMutex.Unlock()
+	if client.recording != nil {
+		return nil
+	}
+
+	recording, err := newRecording(client.RecordingOptions, client.PeerList)
+	if err != nil {
+		return err
+	}
+	client.recording = recording
+	for _, peer := range client.PeerList {
+		peer.sendRecording(true)
+	}
+	return nil
+}
+
+//StopRecording finishes the files of the current recording, and lets every peer know
+func (client *Client) StopRecording() error {
+	client.recordingMutex.Lock()
+	recording := client.recording
+	client.recording = nil
+	client.recordingMutex.Unlock()
+	if recording == nil {
+		return nil
+	}
+
+	for _, peer := range client.PeerList {
+		peer.sendRecording(false)
+	}
+	return recording.close()
+}
+
+//Recording tells whether the call is being recorded
+func (client *Client) Recording() bool {
+	return client.currentRecording() != nil
+}
+
+func (client *Client) currentRecording() *recording {
+	client.recordingMutex.Lock()
+	defer client.recordingMutex.Unlock()
+	return client.recording
+}
+
+//recordPeer records a packet received from a peer, or a lost frame of samples samples if data is nil
+func (client *Client) recordPeer(peer *Peer, data []byte, samples int) {
+	if recording := client.currentRecording(); recording != nil {
+		recording.writePeer(peer, data, samples)
+	}
+}
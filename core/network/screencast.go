@@ -0,0 +1,203 @@
+package network
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexdiract/spear/core/video"
+)
+
+//videoFragmentSize is the largest part of a frame carried by one packet,
+//it keeps encrypted packets under the MTU of common links
+const videoFragmentSize = 1100
+
+//videoBurstSize is the number of packets of a frame sent back to back, before waiting for the next burst
+const videoBurstSize = 8
+
+//videoHeaderSize is the size of the header of a VideoID packet:
+//the packet type, the frame number, the index of the fragment and the number of fragments in the frame
+const videoHeaderSize = 9
+
+//StartScreencast starts sending the screen to every peer, as selected by the capture options
+func (client *Client) StartScreencast() error {
+	client.screencastMutex.Lock()
+	defer client.screencastMutex.Unlock()
+	if client.screencastStop != nil {
+		return nil
+	}
+
+	//The source is checked without reading a frame, which files would not play again
+	if _, _, err := client.videoSource().Size(); err != nil {
+		return err
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	client.screencastStop, client.screencastDone = stop, done
+	go client.screencast(stop, done)
+	return nil
+}
+
+//StopScreencast stops sending the screen, it returns once the last frame was sent
+func (client *Client) StopScreencast() {
+	client.screencastMutex.Lock()
+	defer client.screencastMutex.Unlock()
+	if client.screencastStop != nil {
+		close(client.screencastStop)
+		//A new screencast must not send packets or read the source alongside the old one
+		<-client.screencastDone
+		client.screencastStop, client.screencastDone = nil, nil
+	}
+}
+
+//Screencasting tells whether the screen is being sent
+func (client *Client) Screencasting() bool {
+	client.screencastMutex.Lock()
+	defer client.screencastMutex.Unlock()
+	return client.screencastStop != nil
+}
+
+//SetCaptureOptions changes what part of the screen is shared, it can be called while sharing
+func (client *Client) SetCaptureOptions(options video.CaptureOptions) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	client.captureMutex.Lock()
+	defer client.captureMutex.Unlock()
+	client.captureOptions = &options
+	if client.screen != nil {
+		client.screen.SetOptions(options)
+	}
+	return nil
+}
+
+//CaptureOptions returns what part of the screen is shared, video.DefaultCaptureOptions unless set
+func (client *Client) CaptureOptions() video.CaptureOptions {
+	client.captureMutex.Lock()
+	defer client.captureMutex.Unlock()
+	if client.captureOptions == nil {
+		return video.DefaultCaptureOptions
+	}
+	return *client.captureOptions
+}
+
+//SetVideoSource makes screencasts send the frames of source in place of the screen, nil restores the screen.
+//The previous source is closed
+func (client *Client) SetVideoSource(source video.Source) {
+	client.captureMutex.Lock()
+	defer client.captureMutex.Unlock()
+	if client.source != nil {
+		client.source.Close()
+	}
+	client.source = source
+}
+
+//videoSource returns the source of screencast frames
+func (client *Client) videoSource() video.Source {
+	client.captureMutex.Lock()
+	defer client.captureMutex.Unlock()
+	if client.source != nil {
+		return client.source
+	}
+	//The screen source is kept so that its frame and conversion goroutines serve every capture
+	if client.screen == nil {
+		options := video.DefaultCaptureOptions
+		if client.captureOptions != nil {
+			options = *client.captureOptions
+		}
+		client.screen = video.NewScreenSource(options)
+	}
+	return client.screen
+}
+
+func (client *Client) screencast(stop, done chan struct{}) {
+	defer close(done)
+	encoder := video.NewEncoder()
+	fps := client.CaptureOptions().FPS
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer func() {
+		ticker.Stop()
+	}()
+
+	//Frames are numbered so that receivers can tell their fragments apart
+	var number uint32
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		options := client.CaptureOptions()
+		if options.FPS != fps {
+			fps = options.FPS
+			ticker.Stop()
+			ticker = time.NewTicker(time.Second / time.Duration(fps))
+		}
+		img, err := client.videoSource().Frame()
+		if err != nil {
+			log.Println("Unable to capture the screen: " + err.Error())
+			continue
+		}
+		if atomic.SwapInt32(&client.keyframeRequested, 0) != 0 {
+			encoder.RequestKeyframe()
+		}
+		frame, err := encoder.Encode(img)
+		if err != nil {
+			log.Println("Unable to encode the screen: " + err.Error())
+			continue
+		}
+		client.sendVideoFrame(number, frame, time.Second/time.Duration(fps)/2)
+		number++
+	}
+}
+
+//videoPackets splits an encoded frame into VideoID packets
+func videoPackets(number uint32, frame []byte) [][]byte {
+	count := (len(frame) + videoFragmentSize - 1) / videoFragmentSize
+	if count > math.MaxUint16 {
+		log.Println("Video frame is too large to be sent")
+		return nil
+	}
+	packets := make([][]byte, count)
+	for index := range packets {
+		fragment := frame[index*videoFragmentSize:]
+		if len(fragment) > videoFragmentSize {
+			fragment = fragment[:videoFragmentSize]
+		}
+		packet := make([]byte, videoHeaderSize, videoHeaderSize+len(fragment))
+		packet[0] = VideoID
+		binary.LittleEndian.PutUint32(packet[1:], number)
+		binary.LittleEndian.PutUint16(packet[5:], uint16(index))
+		binary.LittleEndian.PutUint16(packet[7:], uint16(count))
+		packets[index] = append(packet, fragment...)
+	}
+	return packets
+}
+
+//sendVideoFrame sends the packets of an encoded frame to every peer in bursts spread over duration,
+//so that large keyframes do not overflow the socket buffers along the way
+func (client *Client) sendVideoFrame(number uint32, frame []byte, duration time.Duration) {
+	packets := videoPackets(number, frame)
+	bursts := (len(packets) + videoBurstSize - 1) / videoBurstSize
+	for burst := 0; burst < bursts; burst++ {
+		if burst > 0 {
+			time.Sleep(duration / time.Duration(bursts))
+		}
+		last := (burst + 1) * videoBurstSize
+		if last > len(packets) {
+			last = len(packets)
+		}
+		for _, peer := range client.PeerList {
+			for _, packet := range packets[burst*videoBurstSize : last] {
+				peer.sendVideoPacket(packet)
+			}
+		}
+	}
+}
+
+func (client *Client) requestKeyframe() {
+	atomic.StoreInt32(&client.keyframeRequested, 1)
+}
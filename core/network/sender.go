@@ -0,0 +1,125 @@
+package network
+
+import (
+	"log"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+	"gopkg.in/hraban/opus.v2"
+)
+
+//comfortNoiseInterval is the time between two comfort noise indications,
+//they also keep the connection alive while nothing is said
+const comfortNoiseInterval = time.Millisecond * 400
+
+//comfortNoiseTimeout is how long a receiver plays comfort noise after the last indication
+const comfortNoiseTimeout = time.Second
+
+//SendAudio sends a raw audio frame to every peer, encoding it once per distinct encoder settings
+//so that peers with similar network conditions share the same work.
+//Silent frames are replaced by periodic comfort noise indications, muted ones by silent indications.
+//Frames are never considered silent while a clip is played, so that its quiet parts are heard too
+func (client *Client) SendAudio(pcm []float32) {
+	muted := client.Muted()
+	transmitting := !muted && (client.vad.Active(pcm) || client.Injector.Playing())
+
+	client.levelMutex.Lock()
+	client.localLevel = audio.MeasureLevel(pcm)
+	client.transmitting = transmitting
+	client.levelMutex.Unlock()
+
+	if !transmitting {
+		if client.silentFrames%int(comfortNoiseInterval/audio.FrameDuration()) == 0 {
+			level := client.vad.NoiseLevel()
+			if muted {
+				level = 0
+			}
+			for _, peer := range client.PeerList {
+				peer.sendComfortNoise(level)
+			}
+		}
+		client.silentFrames++
+		return
+	}
+	client.silentFrames = 0
+
+	//Peers are grouped in the order of PeerList, so a group keeps its first peer as long as that peer's
+	//settings do not change, and with it its encoder
+	groups := map[audio.Settings][]*Peer{}
+	for _, peer := range client.PeerList {
+		settings := peer.EncoderSettings()
+		groups[settings] = append(groups[settings], peer)
+	}
+
+	//Encoders of groups that no longer exist are dropped so that their state never goes stale.
+	//A recording keeps the best quality encoding sent
+	encoders := make(map[*Peer]*groupEncoder, len(groups))
+	var recorded []byte
+	var recordedBitrate int
+	for settings, peers := range groups {
+		encoder, err := client.encoder(peers[0], settings)
+		if err != nil {
+			log.Println("Unable to create encoder: " + err.Error())
+			continue
+		}
+		encoders[peers[0]] = encoder
+
+		data, err := audio.CompressAudio(encoder.encoder, pcm)
+		if err != nil {
+			log.Println("Unable to encode audio: " + err.Error())
+			continue
+		}
+		for _, peer := range peers {
+			peer.SendOpusData(data)
+		}
+		if settings.Bitrate > recordedBitrate {
+			recorded, recordedBitrate = data, settings.Bitrate
+		}
+	}
+	client.encoders = encoders
+
+	if recording := client.currentRecording(); recording != nil {
+		recording.writeLocal(pcm, recorded)
+	}
+}
+
+//groupEncoder is the encoder of the peers sharing the same settings, keyed by the first of them
+type groupEncoder struct {
+	encoder  *opus.Encoder
+	settings audio.Settings
+}
+
+//encoder returns the encoder of the group led by leader, set up with settings. The encoder of a group
+//is adapted rather than replaced when its settings change, as a new one would be heard as a glitch
+func (client *Client) encoder(leader *Peer, settings audio.Settings) (*groupEncoder, error) {
+	encoder, ok := client.encoders[leader]
+	if !ok {
+		opusEncoder, err := audio.NewEncoder()
+		if err != nil {
+			return nil, err
+		}
+		if err := settings.Apply(opusEncoder); err != nil {
+			return nil, err
+		}
+		return &groupEncoder{encoder: opusEncoder, settings: settings}, nil
+	}
+	if encoder.settings != settings {
+		if err := settings.Apply(encoder.encoder); err != nil {
+			return nil, err
+		}
+		encoder.settings = settings
+	}
+	return encoder, nil
+}
+
+//LocalLevel returns the level of the last captured frame, and whether it was sent to peers
+func (client *Client) LocalLevel() (level audio.Level, transmitting bool) {
+	client.levelMutex.Lock()
+	defer client.levelMutex.Unlock()
+	return client.localLevel, client.transmitting
+}
+
+//PeerLevel returns the level of the last frame played from a peer
+func (client *Client) PeerLevel(peer *Peer) audio.Level {
+	return client.Mixer.Level(peer)
+}
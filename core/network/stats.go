@@ -0,0 +1,169 @@
+package network
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+)
+
+//maximumIDRegression is how far packet ids may go backwards before the sender is assumed to have restarted
+const maximumIDRegression = 1000
+
+//receptionStats estimates loss and jitter of the audio packets received from a peer
+type receptionStats struct {
+	mutex sync.Mutex
+
+	started     bool
+	baseID      uint32
+	highestID   uint32
+	received    uint32
+	total       uint32
+	lastID      uint32
+	lastArrival int64
+	jitter      float64
+	paused      bool
+
+	//frameDuration is the duration of the audio in each packet, as announced by the sender
+	frameDuration time.Duration
+}
+
+func (stats *receptionStats) record(packet *Packet) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if !stats.started || packet.ID+maximumIDRegression < stats.lastID {
+		stats.started = true
+		stats.baseID = packet.ID
+		stats.highestID = packet.ID
+		stats.received = 0
+		stats.total = 0
+		stats.lastID = packet.ID
+		stats.lastArrival = packet.ReceivedTime
+		stats.jitter = 0
+	}
+
+	stats.received++
+	stats.total++
+	if packet.ID > stats.highestID {
+		stats.highestID = packet.ID
+	}
+
+	//Interarrival jitter as in RFC 3550, using the packet id as the media clock.
+	//The first packet after a silence has no meaningful interarrival time
+	if packet.ID > stats.lastID && stats.paused {
+		stats.paused = false
+		stats.lastID = packet.ID
+		stats.lastArrival = packet.ReceivedTime
+	} else if packet.ID > stats.lastID {
+		frameDuration := stats.frameDuration
+		if frameDuration == 0 {
+			frameDuration = audio.DefaultFormat.FrameDuration
+		}
+		expected := float64(packet.ID-stats.lastID) * float64(frameDuration) / float64(time.Millisecond)
+		d := math.Abs(float64(packet.ReceivedTime-stats.lastArrival) - expected)
+		stats.jitter += (d - stats.jitter) / 16
+		stats.lastID = packet.ID
+		stats.lastArrival = packet.ReceivedTime
+	}
+}
+
+func (stats *receptionStats) setFrameDuration(duration time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.frameDuration = duration
+}
+
+//pause tells that the sender stopped sending audio because of silence
+func (stats *receptionStats) pause() {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.paused = true
+}
+
+//collect returns a report covering the packets since the last collect, ok is false if nothing was received
+func (stats *receptionStats) collect() (report Report, ok bool) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if !stats.started || stats.received == 0 {
+		return Report{}, false
+	}
+
+	if stats.highestID >= stats.baseID {
+		expected := stats.highestID - stats.baseID + 1
+		if stats.received < expected {
+			report.Loss = 1 - float32(stats.received)/float32(expected)
+		}
+	}
+	report.Received = stats.total
+	report.Jitter = time.Duration(stats.jitter * float64(time.Millisecond))
+	report.HighestID = stats.highestID
+
+	stats.baseID = stats.highestID + 1
+	stats.received = 0
+	return report, true
+}
+
+//Statistics refers to the quality of the audio exchanged with a peer
+type Statistics struct {
+	RTT time.Duration
+	//Local is the latest report made on the peer's audio
+	Local Report
+	//Remote is the latest report the peer made on our audio
+	Remote        Report
+	RemoteUpdated time.Time
+}
+
+type peerStatistics struct {
+	mutex sync.Mutex
+	Statistics
+}
+
+func (stats *peerStatistics) update(f func(*Statistics)) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	f(&stats.Statistics)
+}
+
+func (stats *peerStatistics) get() Statistics {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	return stats.Statistics
+}
+
+func (peer *Peer) receiveReport(packet *Packet) {
+	report, err := DecodeReport(packet.RawData[1:])
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	stats := peer.Statistics()
+	peer.statistics.update(func(stats *Statistics) {
+		stats.Remote = *report
+		stats.RemoteUpdated = time.Now()
+	})
+	peer.controller.Update(audio.Feedback{
+		Loss:   report.Loss,
+		Jitter: report.Jitter,
+		RTT:    stats.RTT,
+	})
+}
+
+func (peer *Peer) sendReport() {
+	report, ok := peer.reception.collect()
+	if !ok {
+		return
+	}
+	peer.statistics.update(func(stats *Statistics) {
+		stats.Local = report
+	})
+	peer.sendPacket(append([]byte{ReportID}, report.Encode()...))
+}
+
+//Statistics returns the latest audio statistics exchanged with the peer
+func (peer *Peer) Statistics() Statistics {
+	return peer.statistics.get()
+}
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"io"
+
+	"github.com/hexdiract/spear/core/audio"
+)
+
+//StreamAudio runs the audio loop: it captures frames from device, sends them to every peer,
+//and plays back the mix of what peers sent. It returns nil once the device runs out of input
+func (client *Client) StreamAudio(device audio.Device) error {
+	in := make([]float32, audio.FrameSize()*audio.Channels())
+	out := make([]float32, audio.FrameSize()*audio.Channels())
+
+	for {
+		if err := device.Read(in); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if client.EchoCanceller != nil {
+			client.EchoCanceller.Process(in)
+		}
+		client.Preprocessing.Process(in)
+		client.Injector.Process(in)
+		client.SendAudio(in)
+		for _, peer := range client.PeerList {
+			source := audio.Source{Key: peer, Gain: peer.Volume, Pan: peer.Pan}
+			if packet := peer.GetAudioData(); len(packet) == len(out) {
+				source.PCM = packet
+			}
+			client.Mixer.Add(source)
+		}
+		client.Mixer.Mix(out)
+		if client.EchoCanceller != nil {
+			client.EchoCanceller.Playback(out)
+		}
+		if recording := client.currentRecording(); recording != nil {
+			//Only what peers heard of the microphone is recorded
+			var captured []float32
+			if _, transmitting := client.LocalLevel(); transmitting {
+				captured = in
+			}
+			recording.advance(captured, out)
+		}
+
+		if err := device.Write(out); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package network
+
+import (
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+	"github.com/hexdiract/spear/core/crypto"
+)
+
+//writeWAV writes a WAV file of the given duration, its samples being the values of signal at each instant
+func writeWAV(t *testing.T, path string, duration time.Duration, signal func(seconds float64) float32) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := audio.NewWAVWriter(file, audio.SampleRate, audio.Channels())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm := make([]float32, int(audio.SampleRate*duration/time.Second)*audio.Channels())
+	for i := range pcm {
+		pcm[i] = signal(float64(i/audio.Channels()) / audio.SampleRate)
+	}
+	if err := writer.Write(pcm); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//localAddr returns a free UDP address on the loopback interface
+func localAddr(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+//toneLevel returns the amplitude of the frequency component of the first channel of pcm
+func toneLevel(pcm []float32, frequency float64) float64 {
+	var re, im float64
+	samples := len(pcm) / audio.Channels()
+	for i := 0; i < samples; i++ {
+		phase := 2 * math.Pi * frequency * float64(i) / audio.SampleRate
+		re += float64(pcm[i*audio.Channels()]) * math.Cos(phase)
+		im -= float64(pcm[i*audio.Channels()]) * math.Sin(phase)
+	}
+	return 2 * math.Hypot(re, im) / float64(samples)
+}
+
+//listener is the sound card of the receiver: it captures silence and keeps the played frames that hold sound,
+//until enough of them were heard or the deadline passed
+type listener struct {
+	heard    [][]float32
+	want     int
+	deadline time.Time
+}
+
+func (device *listener) Read(in []float32) error {
+	if len(device.heard) >= device.want || time.Now().After(device.deadline) {
+		return io.EOF
+	}
+	for i := range in {
+		in[i] = 0
+	}
+	return nil
+}
+
+func (device *listener) Write(out []float32) error {
+	if toneLevel(out, 440) > 0.01 {
+		device.heard = append(device.heard, append([]float32(nil), out...))
+	}
+	return nil
+}
+
+func (device *listener) Close() error {
+	return nil
+}
+
+//untilDone reads from a Device until done is closed
+type untilDone struct {
+	audio.Device
+	done chan struct{}
+}
+
+func (device untilDone) Read(in []float32) error {
+	select {
+	case <-device.done:
+		return io.EOF
+	default:
+		return device.Device.Read(in)
+	}
+}
+
+func TestStreamAudio(t *testing.T) {
+	tonePath := filepath.Join(t.TempDir(), "tone.wav")
+	//The tone lasts longer than the test, which stops once the receiver heard enough of it
+	writeWAV(t, tonePath, time.Second*10, func(seconds float64) float32 {
+		return float32(0.3 * math.Sin(2*math.Pi*440*seconds))
+	})
+
+	sender, receiver := &Client{SecretKey: crypto.RandomBytes(32)}, &Client{SecretKey: crypto.RandomBytes(32)}
+	sender.Addr.Candidates = []*net.UDPAddr{localAddr(t)}
+	receiver.Addr.Candidates = []*net.UDPAddr{localAddr(t)}
+	for _, pair := range [][2]*Client{{sender, receiver}, {receiver, sender}} {
+		publicKey, err := crypto.CreatePublicKey(pair[1].SecretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair[0].PeerList = []*Peer{{PublicKey: publicKey, Addr: DeterminableAddr{Candidates: pair[1].Addr.Candidates}}}
+	}
+	for _, client := range []*Client{sender, receiver} {
+		if err := client.Initialize(); err != nil {
+			t.Fatal(err)
+		}
+		client := client
+		t.Cleanup(func() {
+			client.Close()
+		})
+	}
+
+	input, err := audio.OpenFileDevice(tonePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		input.Close()
+	})
+	output := &listener{want: 20, deadline: time.Now().Add(time.Second * 5)}
+	done := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- sender.StreamAudio(audio.Realtime(untilDone{Device: input, done: done}))
+	}()
+	err = receiver.StreamAudio(audio.Realtime(output))
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(output.heard) < output.want {
+		t.Fatalf("Receiver heard %d frames of the tone before the deadline, want %d", len(output.heard), output.want)
+	}
+	for i, frame := range output.heard {
+		//The first frame may start with the end of the jitter buffer's silence
+		if tone, other := toneLevel(frame, 440), toneLevel(frame, 1000); i > 0 && (tone < 0.1 || other > tone/10) {
+			t.Fatalf("Frame %d has a 440 Hz level of %.3f and a 1000 Hz level of %.3f, want a clear 440 Hz tone", i, tone, other)
+		}
+	}
+}
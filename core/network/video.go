@@ -0,0 +1,150 @@
+package network
+
+import (
+	"encoding/binary"
+	"image"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexdiract/spear/core/video"
+)
+
+//videoFrameTimeout is how long the fragments of an incomplete frame are kept,
+//a stream that did not complete a frame for that long is assumed to have restarted
+const videoFrameTimeout = time.Second
+
+//keyframeRequestInterval bounds how often a peer is asked for a keyframe
+const keyframeRequestInterval = time.Millisecond * 500
+
+//partialFrame holds the fragments of a frame received so far
+type partialFrame struct {
+	fragments [][]byte
+	received  int
+	started   time.Time
+}
+
+//frameAssembler rebuilds frames from the fragments of VideoID packets
+type frameAssembler struct {
+	frames map[uint32]*partialFrame
+	//last is the number of the last complete frame, fragments of earlier frames are dropped
+	last      uint32
+	completed time.Time
+	started   bool
+}
+
+func newFrameAssembler() *frameAssembler {
+	return &frameAssembler{frames: make(map[uint32]*partialFrame)}
+}
+
+//add stores a fragment and returns the frame it completes, if any.
+//lost is true when frames were dropped since the previous complete one
+func (assembler *frameAssembler) add(number uint32, index, count int, data []byte, now time.Time) (frame []byte, lost bool) {
+	if assembler.started && now.Sub(assembler.completed) > videoFrameTimeout {
+		assembler.started = false
+	}
+	for n, partial := range assembler.frames {
+		if now.Sub(partial.started) > videoFrameTimeout {
+			delete(assembler.frames, n)
+			lost = true
+		}
+	}
+	if assembler.started && int32(number-assembler.last) <= 0 {
+		return nil, lost
+	}
+
+	partial := assembler.frames[number]
+	if partial == nil {
+		partial = &partialFrame{fragments: make([][]byte, count), started: now}
+		assembler.frames[number] = partial
+	}
+	if count != len(partial.fragments) || index >= count || partial.fragments[index] != nil {
+		return nil, lost
+	}
+	partial.fragments[index] = data
+	partial.received++
+	if partial.received < count {
+		return nil, lost
+	}
+
+	for _, fragment := range partial.fragments {
+		frame = append(frame, fragment...)
+	}
+	//Frames between the previous complete one and this one will never be shown
+	if assembler.started && number != assembler.last+1 {
+		lost = true
+	}
+	for n := range assembler.frames {
+		if int32(n-number) <= 0 {
+			delete(assembler.frames, n)
+		}
+	}
+	assembler.last, assembler.completed, assembler.started = number, now, true
+	return frame, lost
+}
+
+//parseVideoPacket reads the header of a VideoID packet
+func parseVideoPacket(data []byte) (number uint32, index, count int, fragment []byte, ok bool) {
+	if len(data) < videoHeaderSize {
+		return 0, 0, 0, nil, false
+	}
+	number = binary.LittleEndian.Uint32(data[1:])
+	index = int(binary.LittleEndian.Uint16(data[5:]))
+	count = int(binary.LittleEndian.Uint16(data[7:]))
+	return number, index, count, data[videoHeaderSize:], count > 0
+}
+
+//videoFrame is a complete frame waiting to be decoded
+type videoFrame struct {
+	data []byte
+	//afterLoss tells whether frames before this one were lost, so that delta frames cannot be applied
+	afterLoss bool
+}
+
+//decodeVideo decodes the frames of the peer's screencast and passes them to the video callback
+func (peer *Peer) decodeVideo(frames <-chan videoFrame) {
+	decoder := video.NewDecoder()
+	for frame := range frames {
+		if frame.afterLoss {
+			decoder.Invalidate()
+		}
+		img, err := decoder.Decode(frame.data)
+		if err != nil {
+			//Delta frames are expected to be refused until the requested keyframe arrives
+			if err != video.ErrMissingReference {
+				log.Println("Unable to decode video from " + peer.DisplayName() + ": " + err.Error())
+			}
+			peer.requestKeyframe()
+			continue
+		}
+		atomic.StoreInt64(&peer.lastVideoFrame, time.Now().UnixNano())
+		peer.videoMutex.Lock()
+		callback := peer.videoCallback
+		peer.videoMutex.Unlock()
+		if callback != nil {
+			callback(img)
+		}
+	}
+}
+
+//requestKeyframe asks the peer for a frame that can be decoded on its own
+func (peer *Peer) requestKeyframe() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&peer.lastKeyframeRequest)
+	if now-last < int64(keyframeRequestInterval) || !atomic.CompareAndSwapInt64(&peer.lastKeyframeRequest, last, now) {
+		return
+	}
+	peer.sendControlPacket(ControlKeyframe, nil)
+}
+
+//SetVideoCallback sets the function called with every frame of the peer's screencast, from a goroutine of the peer
+func (peer *Peer) SetVideoCallback(callback func(image.Image)) {
+	peer.videoMutex.Lock()
+	defer peer.videoMutex.Unlock()
+	peer.videoCallback = callback
+}
+
+//SharingScreen tells whether frames of the peer's screen were received recently
+func (peer *Peer) SharingScreen() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&peer.lastVideoFrame))) < videoFrameTimeout*2
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hexdiract/spear/core/video"
+)
+
+//videoLink carries frames from an Encoder to a Decoder the way a screencast does, through VideoID packets
+type videoLink struct {
+	t         *testing.T
+	encoder   *video.Encoder
+	assembler *frameAssembler
+	decoder   *video.Decoder
+	number    uint32
+	now       time.Time
+}
+
+func newVideoLink(t *testing.T) *videoLink {
+	link := &videoLink{
+		t:         t,
+		encoder:   video.NewEncoder(),
+		assembler: newFrameAssembler(),
+		decoder:   video.NewDecoder(),
+		now:       time.Now(),
+	}
+	return link
+}
+
+//send encodes img and delivers its packets, except those for which drop returns true.
+//It returns the frame completed by the packets, if any, and whether frames were lost before it
+func (link *videoLink) send(img *image.YCbCr, drop func(index int) bool) (frame []byte, lost bool) {
+	data, err := link.encoder.Encode(img)
+	if err != nil {
+		link.t.Fatal("Unable to encode frame: " + err.Error())
+	}
+	packets := videoPackets(link.number, data)
+	link.number++
+	if count := (len(data) + videoFragmentSize - 1) / videoFragmentSize; len(packets) != count {
+		link.t.Fatalf("Frame of %d bytes was sent in %d packets, want %d", len(data), len(packets), count)
+	}
+	link.now = link.now.Add(time.Second / time.Duration(video.ScreencastFPS))
+
+	for _, packet := range packets {
+		if len(packet) > videoHeaderSize+videoFragmentSize || packet[0] != VideoID {
+			link.t.Fatalf("Packet of %d bytes and type %d is not a video packet", len(packet), packet[0])
+		}
+		number, index, count, fragment, ok := parseVideoPacket(packet)
+		if !ok {
+			link.t.Fatal("Unable to parse video packet")
+		}
+		if drop != nil && drop(index) {
+			continue
+		}
+		complete, afterLoss := link.assembler.add(number, index, count, fragment, link.now)
+		if complete != nil {
+			if !bytes.Equal(complete, data) {
+				link.t.Fatal("Assembled frame differs from the encoded one")
+			}
+			frame = complete
+		}
+		lost = lost || afterLoss
+	}
+	return frame, lost
+}
+
+//decode decodes a frame as a peer does, dropping the reference after a loss
+func (link *videoLink) decode(frame []byte, lost bool) (*image.YCbCr, error) {
+	if lost {
+		link.decoder.Invalidate()
+	}
+	img, err := link.decoder.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	ycbcr, ok := img.(*image.YCbCr)
+	if !ok {
+		link.t.Fatalf("Decoded frame is a %T", img)
+	}
+	return ycbcr, nil
+}
+
+//checkFrame fails unless the luma of got is within JPEG losses of want
+func checkFrame(t *testing.T, got, want *image.YCbCr) {
+	if got.Rect.Size() != want.Rect.Size() {
+		t.Fatalf("Decoded frame is %v, want %v", got.Rect, want.Rect)
+	}
+	var difference int
+	for y := 0; y < want.Rect.Dy(); y++ {
+		for x := 0; x < want.Rect.Dx(); x++ {
+			d := int(got.Y[got.YOffset(got.Rect.Min.X+x, got.Rect.Min.Y+y)]) - int(want.Y[want.YOffset(want.Rect.Min.X+x, want.Rect.Min.Y+y)])
+			if d < 0 {
+				d = -d
+			}
+			difference += d
+		}
+	}
+	if mean := float64(difference) / float64(want.Rect.Dx()*want.Rect.Dy()); mean > 4 {
+		t.Fatalf("Decoded luma differs by %.1f on average", mean)
+	}
+}
+
+//streamSource sends frames of source through a link and checks every decoded frame
+func streamSource(t *testing.T, source video.Source, frames int) {
+	link := newVideoLink(t)
+	for i := 0; i < frames; i++ {
+		img, err := source.Frame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, lost := link.send(img, nil)
+		if frame == nil || lost {
+			t.Fatalf("Frame %d was not received whole", i)
+		}
+		decoded, err := link.decode(frame, lost)
+		if err != nil {
+			t.Fatalf("Unable to decode frame %d: %s", i, err)
+		}
+		checkFrame(t, decoded, img)
+	}
+}
+
+func TestVideoTestPattern(t *testing.T) {
+	pattern, err := video.NewTestPattern(640, 360)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamSource(t, pattern, 20)
+}
+
+func TestVideoY4M(t *testing.T) {
+	//Three flat 4x2 frames, each of 8 luma and 2+2 chroma samples
+	fixture := []byte("YUV4MPEG2 W4 H2 F30:1 C420jpeg\n")
+	for _, luma := range []byte{50, 120, 200} {
+		fixture = append(fixture, "FRAME\n"...)
+		fixture = append(fixture, bytes.Repeat([]byte{luma}, 8)...)
+		fixture = append(fixture, 100, 100, 150, 150)
+	}
+	path := filepath.Join(t.TempDir(), "fixture.y4m")
+	if err := os.WriteFile(path, fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := video.NewY4MSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	//Six frames so that the file is played twice
+	streamSource(t, source, 6)
+}
+
+func TestVideoDroppedFragment(t *testing.T) {
+	pattern, err := video.NewTestPattern(640, 360)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := newVideoLink(t)
+	next := func() *image.YCbCr {
+		img, err := pattern.Frame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	for i := 0; i < 3; i++ {
+		frame, lost := link.send(next(), nil)
+		if _, err := link.decode(frame, lost); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//A frame missing its first fragment is never completed
+	if frame, _ := link.send(next(), func(index int) bool { return index == 0 }); frame != nil {
+		t.Fatal("Frame missing a fragment was completed")
+	}
+
+	//The next delta frame arrives after the loss and cannot be applied
+	frame, lost := link.send(next(), nil)
+	if frame == nil {
+		t.Fatal("Frame after the loss was not received")
+	}
+	if !lost {
+		t.Fatal("Frame after the loss is not marked as such")
+	}
+	if _, err := link.decode(frame, lost); err != video.ErrMissingReference {
+		t.Fatalf("Decoding after the loss returned %v, want %v", err, video.ErrMissingReference)
+	}
+
+	//The keyframe the receiver asks for brings the stream back
+	link.encoder.RequestKeyframe()
+	img := next()
+	frame, lost = link.send(img, nil)
+	decoded, err := link.decode(frame, lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, decoded, img)
+}
+
+func TestSendVideoFrame(t *testing.T) {
+	var received [][]byte
+	peer := &Peer{}
+	peer.sendVideoPacket = func(packet []byte) {
+		received = append(received, packet)
+	}
+	client := &Client{PeerList: []*Peer{peer}}
+
+	//Three bursts, the last one being partial
+	frame := make([]byte, videoFragmentSize*(2*videoBurstSize+1))
+	duration := time.Millisecond * 60
+	start := time.Now()
+	client.sendVideoFrame(7, frame, duration)
+	//Two waits of a third of the duration separate the bursts
+	if elapsed := time.Since(start); elapsed < duration*2/3 {
+		t.Errorf("Frame was sent in %v, want it spread over at least %v", elapsed, duration*2/3)
+	}
+	if len(received) != 2*videoBurstSize+1 {
+		t.Fatalf("Peer received %d packets, want %d", len(received), 2*videoBurstSize+1)
+	}
+	for i, packet := range received {
+		if number, index, _, _, ok := parseVideoPacket(packet); !ok || number != 7 || index != i {
+			t.Fatalf("Packet %d is fragment %d of frame %d", i, index, number)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"math"
+	"time"
+)
+
+//Quality is the JPEG quality frames are compressed with, from 1 to 100
+const Quality = 60
+
+//TileSize is the side of the square tiles compared with the previous frame to find what changed,
+//a multiple of the 16 pixels JPEG compresses together so that tiles do not bleed into each other
+const TileSize = 32
+
+//KeyframeInterval is the time between two full refreshes of the screen
+const KeyframeInterval = time.Second * 5
+
+//keyframeTileRatio is the fraction of changed tiles above which a keyframe is sent in place of a delta frame
+const keyframeTileRatio = 0.5
+
+//frameHeaderSize is the size of the header in front of every encoded frame
+const frameHeaderSize = 5
+
+//List of frame flags, carried in the first byte of an encoded frame
+const (
+	//FrameKey marks a frame that can be decoded without the previous ones,
+	//other frames only carry the tiles that changed since the previous frame
+	FrameKey = 1 << 0
+)
+
+//ErrMissingReference is returned when decoding a delta frame without the frames it depends on
+var ErrMissingReference = errors.New("Missing reference frame")
+
+//Encoder compresses screen frames so that they can be sent to peers.
+//A keyframe is a JPEG picture of the whole screen, a delta frame lists the tiles that changed
+//and holds them side by side in a single JPEG picture
+type Encoder struct {
+	keyframeRequested bool
+	//previous is a copy of the last encoded frame, nil before the first one
+	previous     *image.YCbCr
+	lastKeyframe time.Time
+	changed      []int
+}
+
+//NewEncoder creates an Encoder
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+//RequestKeyframe makes the next frame a keyframe, for receivers that lost frames
+func (encoder *Encoder) RequestKeyframe() {
+	encoder.keyframeRequested = true
+}
+
+//Encode compresses a frame, as a header with its flags and size followed by the keyframe or delta frame
+func (encoder *Encoder) Encode(img *image.YCbCr) ([]byte, error) {
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		return nil, errors.New("Only 4:2:0 frames can be encoded")
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	key := encoder.keyframeRequested || encoder.previous == nil || time.Since(encoder.lastKeyframe) >= KeyframeInterval ||
+		encoder.previous.Rect.Dx() != width || encoder.previous.Rect.Dy() != height
+
+	var changed []int
+	if !key {
+		changed = encoder.changedTiles(img)
+		columns, rows := tileGrid(width, height)
+		key = float64(len(changed)) > float64(columns*rows)*keyframeTileRatio
+	}
+
+	var buffer *bytes.Buffer
+	if key {
+		buffer = bytes.NewBuffer(frameHeader(FrameKey, width, height))
+		if err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: Quality}); err != nil {
+			return nil, err
+		}
+		encoder.keyframeRequested = false
+		encoder.lastKeyframe = time.Now()
+	} else {
+		buffer = bytes.NewBuffer(frameHeader(0, width, height))
+		indices := make([]byte, 2+2*len(changed))
+		binary.LittleEndian.PutUint16(indices, uint16(len(changed)))
+		for i, tile := range changed {
+			binary.LittleEndian.PutUint16(indices[2+2*i:], uint16(tile))
+		}
+		buffer.Write(indices)
+		if len(changed) > 0 {
+			if err := jpeg.Encode(buffer, tileMosaic(img, changed), &jpeg.Options{Quality: Quality}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if encoder.previous == nil || !encoder.previous.Rect.Eq(img.Rect) {
+		encoder.previous = image.NewYCbCr(img.Rect, image.YCbCrSubsampleRatio420)
+	}
+	copyTile(encoder.previous, img, img.Rect.Min, img.Rect)
+	return buffer.Bytes(), nil
+}
+
+//changedTiles returns the indices of the tiles that differ from the previous frame
+func (encoder *Encoder) changedTiles(img *image.YCbCr) []int {
+	changed := encoder.changed[:0]
+	columns, rows := tileGrid(img.Rect.Dx(), img.Rect.Dy())
+	for i := 0; i < columns*rows; i++ {
+		if !sameTile(encoder.previous, img, tileRect(img.Rect, columns, i)) {
+			changed = append(changed, i)
+		}
+	}
+	encoder.changed = changed
+	return changed
+}
+
+func frameHeader(flags byte, width, height int) []byte {
+	header := make([]byte, frameHeaderSize)
+	header[0] = flags
+	binary.LittleEndian.PutUint16(header[1:], uint16(width))
+	binary.LittleEndian.PutUint16(header[3:], uint16(height))
+	return header
+}
+
+//Decoder decompresses frames made by an Encoder
+type Decoder struct {
+	//reference is the last decoded frame, which delta frames are applied to
+	reference *image.YCbCr
+}
+
+//NewDecoder creates a Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+//Invalidate drops the reference frame after frames were lost, delta frames are refused until the next keyframe
+func (decoder *Decoder) Invalidate() {
+	decoder.reference = nil
+}
+
+//Decode decompresses a frame. The returned image is not modified by later calls
+func (decoder *Decoder) Decode(data []byte) (image.Image, error) {
+	if len(data) < frameHeaderSize {
+		return nil, errors.New("Video frame is too short")
+	}
+	flags := data[0]
+	width := int(binary.LittleEndian.Uint16(data[1:]))
+	height := int(binary.LittleEndian.Uint16(data[3:]))
+	body := data[frameHeaderSize:]
+
+	if flags&FrameKey != 0 {
+		img, err := decodeYCbCr(body)
+		if err != nil {
+			return nil, err
+		}
+		if img.Rect.Dx() != width || img.Rect.Dy() != height {
+			return nil, errors.New("Video frame size does not match its header")
+		}
+		decoder.reference = cloneYCbCr(img)
+		return img, nil
+	}
+
+	reference := decoder.reference
+	if reference == nil || reference.Rect.Dx() != width || reference.Rect.Dy() != height {
+		return nil, ErrMissingReference
+	}
+	if len(body) < 2 {
+		return nil, errors.New("Video frame is too short")
+	}
+	count := int(binary.LittleEndian.Uint16(body))
+	if len(body) < 2+2*count {
+		return nil, errors.New("Video frame is too short")
+	}
+	if count > 0 {
+		mosaic, err := decodeYCbCr(body[2+2*count:])
+		if err != nil {
+			return nil, err
+		}
+		mosaicColumns := mosaicColumns(count)
+		if mosaic.Rect.Dx() != mosaicColumns*TileSize || mosaic.Rect.Dy() != (count+mosaicColumns-1)/mosaicColumns*TileSize {
+			return nil, errors.New("Video frame size does not match its header")
+		}
+		columns, rows := tileGrid(width, height)
+		for i := 0; i < count; i++ {
+			tile := int(binary.LittleEndian.Uint16(body[2+2*i:]))
+			if tile >= columns*rows {
+				return nil, errors.New("Video frame holds a tile out of the screen")
+			}
+			rect := tileRect(reference.Rect, columns, tile)
+			min := mosaicRect(mosaic.Rect, mosaicColumns, i).Min
+			copyTile(reference, mosaic, rect.Min, image.Rectangle{min, min.Add(rect.Size())})
+		}
+	}
+	return cloneYCbCr(reference), nil
+}
+
+//decodeYCbCr decompresses a JPEG picture made by an Encoder
+func decodeYCbCr(data []byte) (*image.YCbCr, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	ycbcr, ok := img.(*image.YCbCr)
+	if !ok || ycbcr.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		return nil, errors.New("Video frame is not a 4:2:0 picture")
+	}
+	return ycbcr, nil
+}
+
+//tileGrid returns the number of tiles across and down a frame
+func tileGrid(width, height int) (columns, rows int) {
+	return (width + TileSize - 1) / TileSize, (height + TileSize - 1) / TileSize
+}
+
+//tileRect returns the area of a tile of a frame, tiles on the right and bottom edges may be smaller
+func tileRect(bounds image.Rectangle, columns, tile int) image.Rectangle {
+	min := bounds.Min.Add(image.Pt(tile%columns*TileSize, tile/columns*TileSize))
+	return image.Rectangle{min, min.Add(image.Pt(TileSize, TileSize))}.Intersect(bounds)
+}
+
+//mosaicColumns returns the number of tiles across the picture holding count tiles of a delta frame
+func mosaicColumns(count int) int {
+	return int(math.Ceil(math.Sqrt(float64(count))))
+}
+
+//mosaicRect returns the place of the i-th tile in the picture of a delta frame
+func mosaicRect(bounds image.Rectangle, columns, i int) image.Rectangle {
+	min := bounds.Min.Add(image.Pt(i%columns*TileSize, i/columns*TileSize))
+	return image.Rectangle{min, min.Add(image.Pt(TileSize, TileSize))}
+}
+
+//tileMosaic places the changed tiles of a frame side by side in a picture
+func tileMosaic(img *image.YCbCr, tiles []int) *image.YCbCr {
+	columns, _ := tileGrid(img.Rect.Dx(), img.Rect.Dy())
+	mosaicColumns := mosaicColumns(len(tiles))
+	mosaicRows := (len(tiles) + mosaicColumns - 1) / mosaicColumns
+	mosaic := image.NewYCbCr(image.Rect(0, 0, mosaicColumns*TileSize, mosaicRows*TileSize), image.YCbCrSubsampleRatio420)
+	//Gray around tiles smaller than TileSize keeps colors from ringing into them
+	for i := range mosaic.Cb {
+		mosaic.Cb[i], mosaic.Cr[i] = 128, 128
+	}
+	for i, tile := range tiles {
+		copyTile(mosaic, img, mosaicRect(mosaic.Rect, mosaicColumns, i).Min, tileRect(img.Rect, columns, tile))
+	}
+	return mosaic
+}
+
+//copyTile copies the area r of src to dst at point dp. Both points must be even so that chroma samples line up
+func copyTile(dst *image.YCbCr, src *image.YCbCr, dp image.Point, r image.Rectangle) {
+	for y := 0; y < r.Dy(); y++ {
+		copy(dst.Y[dst.YOffset(dp.X, dp.Y+y):][:r.Dx()], src.Y[src.YOffset(r.Min.X, r.Min.Y+y):][:r.Dx()])
+	}
+	chromaWidth := (r.Dx() + 1) / 2
+	for y := 0; y < r.Dy(); y += 2 {
+		i, j := dst.COffset(dp.X, dp.Y+y), src.COffset(r.Min.X, r.Min.Y+y)
+		copy(dst.Cb[i:][:chromaWidth], src.Cb[j:][:chromaWidth])
+		copy(dst.Cr[i:][:chromaWidth], src.Cr[j:][:chromaWidth])
+	}
+}
+
+//sameTile tells whether the area r holds the same pixels in both frames
+func sameTile(a, b *image.YCbCr, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		if !bytes.Equal(a.Y[a.YOffset(r.Min.X, y):][:r.Dx()], b.Y[b.YOffset(r.Min.X, y):][:r.Dx()]) {
+			return false
+		}
+	}
+	chromaWidth := (r.Dx() + 1) / 2
+	for y := r.Min.Y; y < r.Max.Y; y += 2 {
+		i, j := a.COffset(r.Min.X, y), b.COffset(r.Min.X, y)
+		if !bytes.Equal(a.Cb[i:][:chromaWidth], b.Cb[j:][:chromaWidth]) || !bytes.Equal(a.Cr[i:][:chromaWidth], b.Cr[j:][:chromaWidth]) {
+			return false
+		}
+	}
+	return true
+}
+
+//cloneYCbCr returns a copy of a frame
+func cloneYCbCr(img *image.YCbCr) *image.YCbCr {
+	clone := image.NewYCbCr(img.Rect, img.SubsampleRatio)
+	copyTile(clone, img, img.Rect.Min, img.Rect)
+	return clone
+}
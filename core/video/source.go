@@ -0,0 +1,286 @@
+package video
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	//Registers the PNG decoder for image sequences, JPEG being registered by the codec
+	_ "image/png"
+)
+
+//Source produces the frames of a screencast, captured from the screen or read from elsewhere
+type Source interface {
+	//Frame returns the next frame, which may be overwritten by the next call
+	Frame() (*image.YCbCr, error)
+	//Size returns the size of the next frame without reading it, or why it cannot be read
+	Size() (width, height int, err error)
+	Close() error
+}
+
+//ScreenSource captures the area of the screen selected by its options
+type ScreenSource struct {
+	mutex     sync.Mutex
+	options   CaptureOptions
+	converter *converter
+}
+
+//NewScreenSource creates a ScreenSource capturing the area selected by options
+func NewScreenSource(options CaptureOptions) *ScreenSource {
+	return &ScreenSource{options: options, converter: newConverter()}
+}
+
+//SetOptions changes the captured area, from the next frame on
+func (source *ScreenSource) SetOptions(options CaptureOptions) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	source.options = options
+}
+
+//Frame takes a screenshot
+func (source *ScreenSource) Frame() (*image.YCbCr, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return capture(source.options, source.converter)
+}
+
+//Size returns the size of the captured area once scaled down to the maximum resolution
+func (source *ScreenSource) Size() (int, int, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	area, err := captureArea(source.options)
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height := source.options.MaxResolution.fit(area.Dx(), area.Dy())
+	return width, height, nil
+}
+
+//Close stops the conversion goroutines
+func (source *ScreenSource) Close() error {
+	source.converter.close()
+	return nil
+}
+
+//TestPattern draws color bars with a square moving across them, so that every frame changes a few tiles
+type TestPattern struct {
+	background *image.YCbCr
+	frame      int
+}
+
+//testPatternColors are the Y, Cb and Cr values of the bars: white, yellow, cyan, green, magenta, red, blue and black
+var testPatternColors = [][3]uint8{
+	{235, 128, 128}, {210, 16, 146}, {170, 166, 16}, {145, 54, 34},
+	{106, 202, 222}, {81, 90, 240}, {41, 240, 110}, {16, 128, 128},
+}
+
+//NewTestPattern creates a TestPattern of the given size
+func NewTestPattern(width, height int) (*TestPattern, error) {
+	if width < 2 || height < 2 || width > 65535 || height > 65535 {
+		return nil, errors.New("Test pattern size must be between 2x2 and 65535x65535")
+	}
+	background := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			color := testPatternColors[x*len(testPatternColors)/width]
+			background.Y[background.YOffset(x, y)] = color[0]
+			background.Cb[background.COffset(x, y)] = color[1]
+			background.Cr[background.COffset(x, y)] = color[2]
+		}
+	}
+	return &TestPattern{background: background}, nil
+}
+
+//Frame draws the next frame, the square crossing the picture every ten seconds at the default fps
+func (pattern *TestPattern) Frame() (*image.YCbCr, error) {
+	frame := cloneYCbCr(pattern.background)
+	width, height := frame.Rect.Dx(), frame.Rect.Dy()
+	size := height / 4
+	if width < height {
+		size = width / 4
+	}
+	period := ScreencastFPS * 10
+	step := pattern.frame % period
+	pattern.frame++
+
+	x := (width - size) * step / period
+	y := (height - size) / 2
+	for j := y; j < y+size; j++ {
+		for i := x; i < x+size; i++ {
+			frame.Y[frame.YOffset(i, j)] = 128
+			frame.Cb[frame.COffset(i, j)] = 128
+			frame.Cr[frame.COffset(i, j)] = 128
+		}
+	}
+	return frame, nil
+}
+
+//Size returns the size the pattern was created with
+func (pattern *TestPattern) Size() (int, int, error) {
+	return pattern.background.Rect.Dx(), pattern.background.Rect.Dy(), nil
+}
+
+//Close does nothing
+func (pattern *TestPattern) Close() error {
+	return nil
+}
+
+//Y4MSource reads the frames of a YUV4MPEG2 file with 4:2:0 chroma, starting over after the last one
+type Y4MSource struct {
+	file   *os.File
+	reader *bufio.Reader
+	//start is the offset of the first frame in the file
+	start         int64
+	width, height int
+}
+
+//NewY4MSource opens a YUV4MPEG2 file
+func NewY4MSource(path string) (*Y4MSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	source := &Y4MSource{file: file, reader: bufio.NewReader(file)}
+	if err := source.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *Y4MSource) readHeader() error {
+	header, err := source.reader.ReadString('\n')
+	if err != nil {
+		return errors.New("Y4M file is missing its header")
+	}
+	source.start = int64(len(header))
+	fields := strings.Fields(header)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return errors.New("Not a Y4M file")
+	}
+	for _, field := range fields[1:] {
+		switch field[0] {
+		case 'W':
+			source.width, err = strconv.Atoi(field[1:])
+		case 'H':
+			source.height, err = strconv.Atoi(field[1:])
+		case 'C':
+			switch field[1:] {
+			case "420", "420jpeg", "420paldv", "420mpeg2":
+			default:
+				return errors.New("Only 4:2:0 Y4M files can be played")
+			}
+		}
+		if err != nil {
+			return errors.New("Error parsing Y4M header: " + err.Error())
+		}
+	}
+	if source.width <= 0 || source.height <= 0 || source.width > 65535 || source.height > 65535 {
+		return errors.New("Y4M file has an invalid size")
+	}
+	return nil
+}
+
+//Frame reads the next frame
+func (source *Y4MSource) Frame() (*image.YCbCr, error) {
+	line, err := source.reader.ReadString('\n')
+	if err == io.EOF && len(line) == 0 {
+		if _, err := source.file.Seek(source.start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		source.reader.Reset(source.file)
+		line, err = source.reader.ReadString('\n')
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "FRAME") {
+		return nil, errors.New("Corrupted Y4M frame")
+	}
+
+	frame := image.NewYCbCr(image.Rect(0, 0, source.width, source.height), image.YCbCrSubsampleRatio420)
+	for _, plane := range [][]uint8{frame.Y, frame.Cb, frame.Cr} {
+		if _, err := io.ReadFull(source.reader, plane); err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
+
+//Size returns the size given by the header of the file
+func (source *Y4MSource) Size() (int, int, error) {
+	return source.width, source.height, nil
+}
+
+//Close closes the file
+func (source *Y4MSource) Close() error {
+	return source.file.Close()
+}
+
+//ImageSequence reads pictures one per frame, in the order of their names, starting over after the last one
+type ImageSequence struct {
+	paths     []string
+	next      int
+	converter *converter
+}
+
+//NewImageSequence lists the pictures matching a pattern such as frames/*.png
+func NewImageSequence(pattern string) (*ImageSequence, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("No picture matches " + pattern)
+	}
+	sort.Strings(paths)
+	return &ImageSequence{paths: paths, converter: newConverter()}, nil
+}
+
+//Frame reads the next picture
+func (sequence *ImageSequence) Frame() (*image.YCbCr, error) {
+	path := sequence.paths[sequence.next]
+	sequence.next = (sequence.next + 1) % len(sequence.paths)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, errors.New("Unable to read " + path + ": " + err.Error())
+	}
+	if ycbcr, ok := img.(*image.YCbCr); ok && ycbcr.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		return ycbcr, nil
+	}
+	return sequence.converter.convert(toRGBA(img)), nil
+}
+
+//Size reads the size of the next picture from its header
+func (sequence *ImageSequence) Size() (int, int, error) {
+	path := sequence.paths[sequence.next]
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return 0, 0, errors.New("Unable to read " + path + ": " + err.Error())
+	}
+	return config.Width, config.Height, nil
+}
+
+//Close stops the conversion goroutines, pictures are opened one at a time
+func (sequence *ImageSequence) Close() error {
+	sequence.converter.close()
+	return nil
+}
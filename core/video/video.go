@@ -1,96 +1,222 @@
 package video
 
 import (
+	"errors"
 	"image"
 	"image/color"
+	"image/draw"
 	"math"
+	"runtime"
+	"strconv"
+	"sync"
 
 	"github.com/nfnt/resize"
 
 	"github.com/kbinani/screenshot"
 )
 
-//A list of supported aspect ratio
-var (
-	Ratio4to3   = &Resolution{1024, 768}
-	Ratio5to4   = &Resolution{1280, 1024}
-	Ratio3to2   = &Resolution{2160, 1440}
-	Ratio16to10 = &Resolution{1280, 800}
-	Ratio16to9  = &Resolution{1366, 768}
-	Ratio21to9  = &Resolution{2560, 1080}
-	Ratio32to9  = &Resolution{3840, 1080}
-	Ratios      = []*Resolution{Ratio4to3, Ratio5to4, Ratio3to2, Ratio16to10, Ratio16to9, Ratio21to9, Ratio32to9}
-)
-
-//ScreencastFPS refers to screensharing fps
+//ScreencastFPS refers to the default screensharing fps
 const ScreencastFPS = 30
 
-//Resolution refers to the resolution of one casted display
+//Resolution refers to the size of frames
 type Resolution struct {
 	Width, Height int
 }
 
-//Screenshot takes a screenshot from all display
-func Screenshot() ([]*image.YCbCr, error) {
-	images := []*image.YCbCr{}
-	n := screenshot.NumActiveDisplays()
-	for i := 0; i < n; i++ {
-		bounds := screenshot.GetDisplayBounds(i)
-		screenshot, err := screenshot.CaptureRect(bounds)
-		if err != nil {
-			return nil, err
-		}
+//CaptureOptions selects what part of the screen is shared and how large it is sent
+type CaptureOptions struct {
+	//Display is the index of the shared display
+	Display int
+	//Region is the shared area relative to the top left corner of the display, empty for the whole display
+	Region image.Rectangle
+	//MaxResolution bounds the size of frames, larger areas are scaled down keeping their aspect ratio.
+	//A zero width or height does not bound that side
+	MaxResolution Resolution
+	//FPS is the number of frames captured per second
+	FPS int
+}
+
+//DefaultCaptureOptions shares the whole first display at up to 1080p
+var DefaultCaptureOptions = CaptureOptions{
+	MaxResolution: Resolution{1920, 1080},
+	FPS:           ScreencastFPS,
+}
 
-		img, size := fitNearestRatio(screenshot)
+//Validate checks the options that do not depend on the displays connected
+func (options CaptureOptions) Validate() error {
+	if options.Display < 0 {
+		return errors.New("Display must be 0 or more")
+	}
+	if options.Region.Dx() < 0 || options.Region.Dy() < 0 || options.Region.Min.X < 0 || options.Region.Min.Y < 0 {
+		return errors.New("Region must have a positive position and size")
+	}
+	if options.MaxResolution.Width < 0 || options.MaxResolution.Height < 0 {
+		return errors.New("Maximum resolution must be positive")
+	}
+	if options.FPS <= 0 || options.FPS > 120 {
+		return errors.New("FPS must be between 1 and 120")
+	}
+	return nil
+}
 
-		images = append(images, rgbToYCbCr420(&img, size))
+//Displays returns the bounds of every active display
+func Displays() []image.Rectangle {
+	displays := make([]image.Rectangle, screenshot.NumActiveDisplays())
+	for i := range displays {
+		displays[i] = screenshot.GetDisplayBounds(i)
 	}
-	return images, nil
+	return displays
 }
 
-func fitNearestRatio(img *image.RGBA) (image.Image, *Resolution) {
-	ratioDiff := 10.0
-	var fittestRatio *Resolution
-	for _, ratio := range Ratios {
-		whRato := float64(ratio.Width) / float64(ratio.Height)
-		imgWhRatio := float64(img.Bounds().Dx()) / float64(img.Bounds().Dy())
-		if diff := math.Abs(whRato - imgWhRatio); diff < ratioDiff {
-			ratioDiff = diff
-			fittestRatio = ratio
+//captureArea returns the area of the screen selected by options
+func captureArea(options CaptureOptions) (image.Rectangle, error) {
+	if options.Display >= screenshot.NumActiveDisplays() {
+		return image.Rectangle{}, errors.New("Display " + strconv.Itoa(options.Display) + " is not active")
+	}
+	area := screenshot.GetDisplayBounds(options.Display)
+	if !options.Region.Empty() {
+		area = options.Region.Add(area.Min).Intersect(area)
+		if area.Empty() {
+			return image.Rectangle{}, errors.New("Region is outside of the display")
 		}
 	}
+	return area, nil
+}
+
+//capture takes a screenshot of the area selected by options and converts it with converter
+func capture(options CaptureOptions, converter *converter) (*image.YCbCr, error) {
+	area, err := captureArea(options)
+	if err != nil {
+		return nil, err
+	}
+	img, err := screenshot.CaptureRect(area)
+	if err != nil {
+		return nil, err
+	}
 
-	var casted image.Image = img
-	casted = resize.Resize(uint(fittestRatio.Width), uint(fittestRatio.Height), casted, resize.Lanczos3)
-	return casted, fittestRatio
+	var scaled image.Image = img
+	if width, height := options.MaxResolution.fit(area.Dx(), area.Dy()); width != area.Dx() || height != area.Dy() {
+		scaled = resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+	}
+	return converter.convert(toRGBA(scaled)), nil
 }
 
-func rgbToYCbCr420(rgba *image.Image, size *Resolution) *image.YCbCr {
-	img := image.NewYCbCr(image.Rect(0, 0, size.Width, size.Height), image.YCbCrSubsampleRatio420)
-
-	//Init Y
-	i := 0
-	for x := 0; x < size.Width; x++ {
-		for y := 0; y < size.Height; y++ {
-			r, g, b, _ := (*rgba).At(x, y).RGBA()
-			Y, _, _ := color.RGBToYCbCr(uint8(r), uint8(g), uint8(b))
-			img.Y[i] = Y
-			i++
-		}
+//fit returns the size of an area scaled down to fit in the resolution, keeping its aspect ratio
+func (resolution Resolution) fit(width, height int) (int, int) {
+	scale := 1.0
+	if resolution.Width > 0 && width > resolution.Width {
+		scale = float64(resolution.Width) / float64(width)
+	}
+	if resolution.Height > 0 && height > resolution.Height {
+		scale = math.Min(scale, float64(resolution.Height)/float64(height))
+	}
+	if scale == 1 {
+		return width, height
 	}
+	return int(math.Max(1, math.Round(float64(width)*scale))), int(math.Max(1, math.Round(float64(height)*scale)))
+}
 
-	//Init Cr, Cb
-	resized := resize.Resize(uint(size.Width/2), uint(size.Height/2), *rgba, resize.Lanczos3)
-	i = 0
-	for x := 0; x < size.Width/2; x++ {
-		for y := 0; y < size.Height/2; y++ {
-			r, g, b, _ := resized.At(x, y).RGBA()
-			_, Cb, Cr := color.RGBToYCbCr(uint8(r), uint8(g), uint8(b))
-			img.Cb[i] = Cb
-			img.Cr[i] = Cr
-			i++
-		}
+//toRGBA returns img as an *image.RGBA, copying it only if it is of another type
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
 	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+//rowBand is a part of a picture converted by one of the goroutines of a converter
+type rowBand struct {
+	frame  *image.YCbCr
+	img    *image.RGBA
+	y0, y1 int
+}
 
-	return img
+//converter turns RGBA pictures into 4:2:0 frames, each chroma sample being computed from the average
+//of the 2x2 pixels it covers. Bands of rows are converted in parallel by goroutines started once,
+//and the frame is reused, so that converting pictures of the same size allocates nothing
+type converter struct {
+	frame *image.YCbCr
+	bands chan rowBand
+	wait  sync.WaitGroup
+}
+
+//newConverter starts the goroutines of a converter, they run until close is called
+func newConverter() *converter {
+	converter := &converter{bands: make(chan rowBand)}
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		go func() {
+			for band := range converter.bands {
+				convertRows(band.frame, band.img, band.y0, band.y1)
+				converter.wait.Done()
+			}
+		}()
+	}
+	return converter
+}
+
+//convert converts img into the frame of the converter, which is overwritten by the next call
+func (converter *converter) convert(img *image.RGBA) *image.YCbCr {
+	bounds := img.Rect
+	frame := converter.frame
+	if frame == nil || frame.Rect.Dx() != bounds.Dx() || frame.Rect.Dy() != bounds.Dy() {
+		frame = image.NewYCbCr(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), image.YCbCrSubsampleRatio420)
+		converter.frame = frame
+	}
+
+	//Bands hold an even number of rows so that they do not share chroma samples
+	chromaRows := (bounds.Dy() + 1) / 2
+	bands := runtime.GOMAXPROCS(0)
+	if bands > chromaRows {
+		bands = chromaRows
+	}
+	converter.wait.Add(bands)
+	for band := 0; band < bands; band++ {
+		converter.bands <- rowBand{frame, img, band * chromaRows / bands * 2, (band + 1) * chromaRows / bands * 2}
+	}
+	converter.wait.Wait()
+	return frame
+}
+
+//close stops the goroutines of the converter
+func (converter *converter) close() {
+	close(converter.bands)
+}
+
+//convertRows converts the rows of img from y0 to y1, y0 being even, into frame
+func convertRows(frame *image.YCbCr, img *image.RGBA, y0, y1 int) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if y1 > height {
+		y1 = height
+	}
+	for y := y0; y < y1; y += 2 {
+		//Pixels of the row below, the last row of an odd height being averaged with itself
+		below := y + 1
+		if below == height {
+			below = y
+		}
+		top := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		bottom := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+below):]
+		yTop := frame.Y[y*frame.YStride:]
+		yBottom := frame.Y[below*frame.YStride:]
+		cb := frame.Cb[y/2*frame.CStride:]
+		cr := frame.Cr[y/2*frame.CStride:]
+
+		for x := 0; x < width; x += 2 {
+			right := x + 1
+			if right == width {
+				right = x
+			}
+			i, j := x*4, right*4
+			r := int(top[i]) + int(top[j]) + int(bottom[i]) + int(bottom[j])
+			g := int(top[i+1]) + int(top[j+1]) + int(bottom[i+1]) + int(bottom[j+1])
+			b := int(top[i+2]) + int(top[j+2]) + int(bottom[i+2]) + int(bottom[j+2])
+			yTop[x], _, _ = color.RGBToYCbCr(top[i], top[i+1], top[i+2])
+			yTop[right], _, _ = color.RGBToYCbCr(top[j], top[j+1], top[j+2])
+			yBottom[x], _, _ = color.RGBToYCbCr(bottom[i], bottom[i+1], bottom[i+2])
+			yBottom[right], _, _ = color.RGBToYCbCr(bottom[j], bottom[j+1], bottom[j+2])
+			_, cb[x/2], cr[x/2] = color.RGBToYCbCr(uint8((r+2)/4), uint8((g+2)/4), uint8((b+2)/4))
+		}
+	}
 }
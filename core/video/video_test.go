@@ -0,0 +1,149 @@
+package video
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//update rewrites the golden files of the tests with the current output
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func randomRGBA(rect image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(rect)
+	random := rand.New(rand.NewSource(1))
+	random.Read(img.Pix)
+	return img
+}
+
+func TestRGBToYCbCr420(t *testing.T) {
+	tests := []struct {
+		name string
+		rect image.Rectangle
+	}{
+		{"even", image.Rect(0, 0, 64, 32)},
+		{"odd size", image.Rect(0, 0, 7, 5)},
+		{"odd size and offset", image.Rect(3, 1, 10, 6)},
+		{"single pixel", image.Rect(5, 5, 6, 6)},
+		{"single row", image.Rect(2, 0, 11, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := randomRGBA(test.rect)
+			converter := newConverter()
+			defer converter.close()
+			frame := converter.convert(img)
+			width, height := test.rect.Dx(), test.rect.Dy()
+			if frame.Rect.Dx() != width || frame.Rect.Dy() != height {
+				t.Fatalf("Frame is %v, want %dx%d", frame.Rect, width, height)
+			}
+
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					c := img.RGBAAt(test.rect.Min.X+x, test.rect.Min.Y+y)
+					want, _, _ := color.RGBToYCbCr(c.R, c.G, c.B)
+					if got := frame.Y[frame.YOffset(x, y)]; got != want {
+						t.Fatalf("Y at (%d, %d) is %d, want %d", x, y, got, want)
+					}
+				}
+			}
+
+			//Chroma comes from the average of the 2x2 pixels, those past the edges repeating the last ones
+			for y := 0; y < height; y += 2 {
+				for x := 0; x < width; x += 2 {
+					var r, g, b int
+					for _, p := range []image.Point{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}} {
+						if p.X == width {
+							p.X--
+						}
+						if p.Y == height {
+							p.Y--
+						}
+						c := img.RGBAAt(test.rect.Min.X+p.X, test.rect.Min.Y+p.Y)
+						r, g, b = r+int(c.R), g+int(c.G), b+int(c.B)
+					}
+					_, cb, cr := color.RGBToYCbCr(uint8((r+2)/4), uint8((g+2)/4), uint8((b+2)/4))
+					offset := frame.COffset(x, y)
+					if frame.Cb[offset] != cb || frame.Cr[offset] != cr {
+						t.Fatalf("Chroma at (%d, %d) is %d, %d, want %d, %d", x, y, frame.Cb[offset], frame.Cr[offset], cb, cr)
+					}
+				}
+			}
+		})
+	}
+}
+
+//goldenFixtures are pictures stored in testdata with the 4:2:0 frames they convert to, as Y4M files
+var goldenFixtures = []string{"gradient", "bars"}
+
+func TestRGBToYCbCr420Golden(t *testing.T) {
+	converter := newConverter()
+	defer converter.close()
+	for _, name := range goldenFixtures {
+		t.Run(name, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("testdata", name+".png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			img, err := png.Decode(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := converter.convert(toRGBA(img))
+
+			path := filepath.Join("testdata", name+".y4m")
+			if *update {
+				header := fmt.Sprintf("YUV4MPEG2 W%d H%d F30:1 C420jpeg\nFRAME\n", frame.Rect.Dx(), frame.Rect.Dy())
+				data := append(append(append([]byte(header), frame.Y...), frame.Cb...), frame.Cr...)
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			golden, err := NewY4MSource(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer golden.Close()
+			want, err := golden.Frame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !frame.Rect.Eq(want.Rect) || !bytes.Equal(frame.Y, want.Y) || !bytes.Equal(frame.Cb, want.Cb) || !bytes.Equal(frame.Cr, want.Cr) {
+				t.Fatalf("Frame differs from %s", path)
+			}
+		})
+	}
+}
+
+func TestConverterReusesFrame(t *testing.T) {
+	converter := newConverter()
+	defer converter.close()
+	first := converter.convert(randomRGBA(image.Rect(0, 0, 64, 32)))
+	if second := converter.convert(randomRGBA(image.Rect(0, 0, 64, 32))); second != first {
+		t.Fatal("Frame of the same size was not reused")
+	}
+	if third := converter.convert(randomRGBA(image.Rect(0, 0, 32, 32))); third == first || third.Rect.Dx() != 32 {
+		t.Fatal("Frame was not resized")
+	}
+}
+
+func BenchmarkRGBToYCbCr420(b *testing.B) {
+	img := randomRGBA(image.Rect(0, 0, 1920, 1080))
+	converter := newConverter()
+	defer converter.close()
+	converter.convert(img)
+	b.SetBytes(int64(len(img.Pix)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		converter.convert(img)
+	}
+}
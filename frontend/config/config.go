@@ -3,9 +3,16 @@ package config
 import (
 	"encoding/base64"
 	"errors"
+	"image"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hexdiract/spear/core/audio"
+	"github.com/hexdiract/spear/core/crypto"
 	"github.com/hexdiract/spear/core/network"
+	"github.com/hexdiract/spear/core/video"
+	"gopkg.in/hraban/opus.v2"
 )
 
 //Section refers a list of content under [name]
@@ -17,29 +24,79 @@ type Section struct {
 //Configuration refers to the content of a spear config file
 type Configuration []*Section
 
-//CreateClient creates a network.Client from Configuration
-func CreateClient(config *Configuration) (*network.Client, error) {
+//Options refers to the settings of the frontend that are not part of network.Client
+type Options struct {
+	//InputDevice and OutputDevice select sound cards by name or index, empty means default
+	InputDevice  string
+	OutputDevice string
+	//Record starts recording the call as soon as the client runs
+	Record bool
+	//Clips are the paths of the sound files that can be played into the call
+	Clips []string
+}
+
+//CreateClient creates a network.Client and Options from Configuration
+func CreateClient(config *Configuration) (*network.Client, *Options, error) {
 	client := network.Client{}
+	options := Options{}
+
+	//The audio format comes first as the rest of the audio pipeline is sized after it
+	audioSections := config.GetSections("audio")
+	if len(audioSections) > 1 {
+		return nil, nil, errors.New("Multiple [audio] found")
+	}
+	for _, section := range audioSections {
+		if err := readAudioSection(section); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	sections := config.GetSections("client")
 	if len(sections) != 1 {
-		return nil, errors.New("Multiple or no [client] found")
+		return nil, nil, errors.New("Multiple or no [client] found")
+	}
+
+	if err := readClientSection(sections[0], &client, &options); err != nil {
+		return nil, nil, err
+	}
+	if len(client.SecretKey) == 0 {
+		return nil, nil, errors.New("No sk found in [client]")
+	}
+	if _, err := crypto.CreatePublicKey(client.SecretKey); err != nil {
+		return nil, nil, errors.New("Invalid sk: " + err.Error())
+	}
+
+	client.RecordingOptions.Directory = "."
+	recordingSections := config.GetSections("recording")
+	if len(recordingSections) > 1 {
+		return nil, nil, errors.New("Multiple [recording] found")
+	}
+	for _, section := range recordingSections {
+		if err := readRecordingSection(section, &client, &options); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	if err := readClientSection(sections[0], &client); err != nil {
-		return nil, err
+	videoSections := config.GetSections("video")
+	if len(videoSections) > 1 {
+		return nil, nil, errors.New("Multiple [video] found")
+	}
+	for _, section := range videoSections {
+		if err := readVideoSection(section, &client); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	for _, section := range config.GetSections("peer") {
 		if err := readPeerSection(section, &client); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	return &client, nil
+	return &client, &options, nil
 }
 
-func readClientSection(section *Section, client *network.Client) error {
+func readClientSection(section *Section, client *network.Client, options *Options) error {
 	for key, value := range section.Content {
 		switch key {
 		case "sk":
@@ -56,6 +113,28 @@ func readClientSection(section *Section, client *network.Client) error {
 				}
 				client.Addr.Candidates = append(client.Addr.Candidates, parsedAddr)
 			}
+		case "echo_cancellation":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("Error parsing echo_cancellation: " + err.Error())
+			}
+			if enabled {
+				client.EchoCanceller = audio.NewEchoCanceller()
+			}
+		case "preprocessing":
+			for _, name := range readList(value) {
+				processor, err := createProcessor(name)
+				if err != nil {
+					return err
+				}
+				client.Preprocessing = append(client.Preprocessing, processor)
+			}
+		case "input":
+			options.InputDevice = value
+		case "output":
+			options.OutputDevice = value
+		case "clips":
+			options.Clips = append(options.Clips, readList(value)...)
 		default:
 			return errors.New("Key " + key + " is not recognized")
 		}
@@ -63,6 +142,188 @@ func readClientSection(section *Section, client *network.Client) error {
 	return nil
 }
 
+func readAudioSection(section *Section) error {
+	//The mode picks the base format, so it is read before the keys overriding parts of it
+	format := audio.DefaultFormat
+	if mode, ok := section.Content["mode"]; ok {
+		switch strings.ToLower(mode) {
+		case "voice":
+		case "music":
+			format = audio.MusicFormat
+		default:
+			return errors.New("Mode must be voice or music")
+		}
+	}
+	for key, value := range section.Content {
+		switch key {
+		case "mode":
+		case "frame_duration":
+			ms, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return errors.New("Error parsing frame_duration: " + err.Error())
+			}
+			format.FrameDuration = time.Duration(ms * float64(time.Millisecond))
+		case "bitrate":
+			bitrate, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("Error parsing bitrate: " + err.Error())
+			}
+			format.Bitrate = bitrate
+		case "complexity":
+			complexity, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("Error parsing complexity: " + err.Error())
+			}
+			format.Complexity = complexity
+		case "channels":
+			switch strings.ToLower(value) {
+			case "mono", "1":
+				format.Channels = 1
+			case "stereo", "2":
+				format.Channels = 2
+			default:
+				return errors.New("Channels must be mono or stereo")
+			}
+		case "application":
+			switch strings.ToLower(value) {
+			case "voip":
+				format.Application = opus.AppVoIP
+			case "audio":
+				format.Application = opus.AppAudio
+			case "lowdelay":
+				format.Application = opus.AppRestrictedLowdelay
+			default:
+				return errors.New("Application must be voip, audio or lowdelay")
+			}
+		default:
+			return errors.New("Key " + key + " is not recognized")
+		}
+	}
+	return audio.SetFormat(format)
+}
+
+func readRecordingSection(section *Section, client *network.Client, options *Options) error {
+	for key, value := range section.Content {
+		switch key {
+		case "directory":
+			client.RecordingOptions.Directory = value
+		case "mode":
+			switch strings.ToLower(value) {
+			case "separate":
+				client.RecordingOptions.Mixed = false
+			case "mixed":
+				client.RecordingOptions.Mixed = true
+			default:
+				return errors.New("Recording mode must be separate or mixed")
+			}
+		case "start":
+			start, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("Error parsing start: " + err.Error())
+			}
+			options.Record = start
+		default:
+			return errors.New("Key " + key + " is not recognized")
+		}
+	}
+	return nil
+}
+
+func readVideoSection(section *Section, client *network.Client) error {
+	options := video.DefaultCaptureOptions
+	for key, value := range section.Content {
+		switch key {
+		case "source":
+		case "display":
+			display, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("Error parsing display: " + err.Error())
+			}
+			options.Display = display
+		case "region":
+			values := readList(value)
+			if len(values) != 4 {
+				return errors.New("Region must be x, y, width, height")
+			}
+			var region [4]int
+			for i, v := range values {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return errors.New("Error parsing region: " + err.Error())
+				}
+				region[i] = n
+			}
+			//image.Rect would swap the corners of a negative size and select another area
+			if region[2] <= 0 || region[3] <= 0 {
+				return errors.New("Region width and height must be positive")
+			}
+			options.Region = image.Rect(region[0], region[1], region[0]+region[2], region[1]+region[3])
+		case "max_resolution":
+			resolution, err := parseResolution(value)
+			if err != nil {
+				return err
+			}
+			options.MaxResolution = resolution
+		case "fps":
+			fps, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("Error parsing fps: " + err.Error())
+			}
+			options.FPS = fps
+		default:
+			return errors.New("Key " + key + " is not recognized")
+		}
+	}
+	if err := client.SetCaptureOptions(options); err != nil {
+		return err
+	}
+
+	//Sources other than the screen are sized after the maximum resolution when they draw their frames
+	source, err := createVideoSource(strings.TrimSpace(section.Content["source"]), options.MaxResolution)
+	if err != nil {
+		return err
+	}
+	client.SetVideoSource(source)
+	return nil
+}
+
+//createVideoSource creates the video source named in [video], nil standing for the screen
+func createVideoSource(name string, resolution video.Resolution) (video.Source, error) {
+	switch {
+	case name == "" || strings.ToLower(name) == "screen":
+		return nil, nil
+	case strings.ToLower(name) == "test_pattern":
+		if resolution.Width == 0 || resolution.Height == 0 {
+			resolution = video.DefaultCaptureOptions.MaxResolution
+		}
+		return video.NewTestPattern(resolution.Width, resolution.Height)
+	case strings.HasSuffix(strings.ToLower(name), ".y4m"):
+		return video.NewY4MSource(name)
+	}
+	return video.NewImageSequence(name)
+}
+
+//parseResolution parses a resolution written as widthxheight, or native for no limit
+func parseResolution(str string) (video.Resolution, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "native" {
+		return video.Resolution{}, nil
+	}
+	parts := strings.Split(str, "x")
+	if len(parts) != 2 {
+		return video.Resolution{}, errors.New("Resolution must be widthxheight or native")
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return video.Resolution{}, errors.New("Error parsing resolution: " + err.Error())
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return video.Resolution{}, errors.New("Error parsing resolution: " + err.Error())
+	}
+	return video.Resolution{Width: width, Height: height}, nil
+}
+
 func readPeerSection(section *Section, client *network.Client) error {
 	peer := network.Peer{}
 	for key, value := range section.Content {
@@ -87,14 +348,42 @@ func readPeerSection(section *Section, client *network.Client) error {
 				return errors.New("Peer name must be not be empty and within 40 characters")
 			}
 			peer.Name = value
+		case "pan":
+			pan, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return errors.New("Error parsing pan: " + err.Error())
+			}
+			if pan < -1 || pan > 1 {
+				return errors.New("Peer pan must be between -1 (left) and 1 (right)")
+			}
+			peer.Pan = float32(pan)
 		default:
 			return errors.New("Key " + key + " is not recognized")
 		}
 	}
+	//A key that cannot be exchanged with would fail every packet of the call
+	if len(peer.PublicKey) == 0 {
+		return errors.New("No pk found in [peer]")
+	}
+	if err := crypto.CheckKeys(peer.PublicKey, client.SecretKey); err != nil {
+		return errors.New("Invalid pk of peer " + peer.DisplayName() + ": " + err.Error())
+	}
 	client.PeerList = append(client.PeerList, &peer)
 	return nil
 }
 
+func createProcessor(name string) (audio.Processor, error) {
+	switch strings.ToLower(name) {
+	case "high_pass":
+		return audio.NewHighPass(80), nil
+	case "noise_gate":
+		return audio.NewNoiseGate(), nil
+	case "agc":
+		return audio.NewAGC(), nil
+	}
+	return nil, errors.New("Preprocessing " + name + " is not recognized")
+}
+
 func readList(list string) []string {
 	values := strings.Split(list, ",")
 	for i, v := range values {
@@ -4,10 +4,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"encoding/base64"
 
-	"github.com/gordonklaus/portaudio"
 	"github.com/hexdiract/spear/core/audio"
 	"github.com/hexdiract/spear/core/crypto"
 	"github.com/hexdiract/spear/core/network"
@@ -19,69 +19,105 @@ import (
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: spear [config path]")
+		fmt.Println("       spear devices")
 		return
 	}
 
-	conf, err := config.ParseFile(os.Args[1])
+	var err error
+	if os.Args[1] == "devices" {
+		err = printDevices()
+	} else {
+		err = run(os.Args[1])
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
 	}
+}
 
-	client, err := config.CreateClient(conf)
+func run(path string) error {
+	conf, err := config.ParseFile(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	log.Println("Current public key: " + base64.StdEncoding.EncodeToString(crypto.CreatePublicKey(client.SecretKey)))
+	client, options, err := config.CreateClient(conf)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := crypto.CreatePublicKey(client.SecretKey)
+	if err != nil {
+		return err
+	}
+	log.Println("Current public key: " + base64.StdEncoding.EncodeToString(publicKey))
 	log.Printf("%d peers found\n", len(client.PeerList))
 
 	if err := client.Initialize(); err != nil {
-		panic(err)
+		return err
 	}
 
-	log.Println("Starting client")
-
-	go startAudioCallback(client)
-	ui.NewLayout(client)
-}
-
-func startAudioCallback(client *network.Client) {
-	if err := portaudio.Initialize(); err != nil {
-		panic(err)
+	device, err := audio.OpenPortAudio(options.InputDevice, options.OutputDevice)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+	if rate := device.SampleRate(); rate != audio.SampleRate {
+		log.Printf("Sound cards run at %d Hz, audio is resampled\n", rate)
 	}
 
-	in := make([]float32, audio.FrameSize)
-	out := make([]float32, audio.FrameSize)
-
-	encoder := audio.NewEncoder()
+	log.Println("Starting client")
 
-	stream, err := portaudio.OpenDefaultStream(1, 1, audio.SampleRate, audio.FrameSize, in, out)
-	if err != nil {
-		panic(err)
+	if options.Record {
+		if err := client.StartRecording(); err != nil {
+			return err
+		}
 	}
+	defer client.StopRecording()
 
-	if err := stream.Start(); err != nil {
-		panic(err)
-	}
+	go startAudioCallback(client, device)
+	return ui.NewLayout(client, device, options.Clips)
+}
 
+//startAudioCallback runs the audio loop, restarting it after errors
+//so that the call goes on once the device recovers or is switched
+func startAudioCallback(client *network.Client, device audio.Device) {
 	for {
-		if err := stream.Read(); err != nil {
-			//log.Println("Error while reading stream: " + err.Error())
+		err := client.StreamAudio(device)
+		if err == nil {
+			return
 		}
+		log.Println("Audio stopped: " + err.Error())
+		time.Sleep(time.Second)
+	}
+}
 
-		for i := 0; i < len(out); i++ {
-			out[i] = 0
-		}
+func printDevices() error {
+	devices, err := audio.ListDevices()
+	if err != nil {
+		return err
+	}
 
-		data := audio.CompressAudio(encoder, in)
-		for _, peer := range client.PeerList {
-			peer.SendOpusData(data)
-			if packet := peer.GetAudioData(); packet != nil && len(packet) == audio.FrameSize {
-				for i := 0; i < len(packet); i++ {
-					out[i] += packet[i] * peer.Volume
-				}
+	printList := func(title string, input bool) {
+		fmt.Println(title)
+		for _, device := range devices {
+			channels, isDefault := device.OutputChannels, device.DefaultOutput
+			if input {
+				channels, isDefault = device.InputChannels, device.DefaultInput
+			}
+			if channels == 0 {
+				continue
+			}
+			line := fmt.Sprintf("  %d: %s (%s, %d channels, %.0f Hz)", device.Index, device.Name, device.HostAPI, channels, device.SampleRate)
+			if isDefault {
+				line += " [default]"
 			}
+			fmt.Println(line)
 		}
-		stream.Write()
 	}
+	printList("Capture devices:", true)
+	printList("Playback devices:", false)
+	fmt.Println()
+	fmt.Println("Select them with input = [name or index] and output = [name or index] under [Client]")
+	return nil
 }
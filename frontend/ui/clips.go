@@ -0,0 +1,89 @@
+package ui
+
+import (
+	"path/filepath"
+
+	"github.com/gdamore/tcell"
+	"github.com/hexdiract/spear/core/audio"
+)
+
+type clipList struct {
+	visible  bool
+	clips    []string
+	selected int
+	playing  string
+	message  string
+}
+
+func (layout *layout) toggleClipList() {
+	list := &layout.clipList
+	list.visible = !list.visible
+	list.message = ""
+	if len(list.clips) == 0 {
+		list.message = "No sound files, list them with clips = [paths] under [Client]"
+	}
+}
+
+func (layout *layout) drawClipList(writer *writer) {
+	list := &layout.clipList
+	writer.writeAt("  Up or Down arrow key to select file, A to play it along the microphone, X to play it instead, S to stop, F to go back.")
+	writer.nextLine()
+	writer.nextLine()
+	for i, clip := range list.clips {
+		if i == list.selected {
+			writer.writeAt(">")
+		}
+		writer.x += 2
+		writer.writeAt(filepath.Base(clip))
+		writer.x += 40
+		writer.writeAt(clip)
+		writer.nextLine()
+	}
+	writer.nextLine()
+	writer.writeAt("  " + list.message)
+}
+
+//handleClipKey handles a key while the clip list is shown, it returns false for keys it ignores
+func (layout *layout) handleClipKey(event *tcell.EventKey) bool {
+	list := &layout.clipList
+	switch event.Key() {
+	case tcell.KeyUp:
+		list.selected--
+	case tcell.KeyDown:
+		list.selected++
+	case tcell.KeyEscape:
+		layout.toggleClipList()
+		return true
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case 'a', 'x':
+			if len(list.clips) == 0 {
+				return true
+			}
+			path := list.clips[list.selected]
+			clip, err := audio.OpenClip(path)
+			if err != nil {
+				list.message = "Unable to play " + filepath.Base(path) + ": " + err.Error()
+				return true
+			}
+			layout.client.Injector.Play(clip, event.Rune() == 'x')
+			list.playing = path
+			list.message = "Playing " + filepath.Base(path)
+		case 's':
+			layout.client.Injector.Stop()
+			list.message = "Stopped"
+		case 'f':
+			layout.toggleClipList()
+		default:
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+
+	if m := len(list.clips); m > 0 {
+		list.selected = (list.selected + m) % m
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package ui
+
+import (
+	"strconv"
+
+	"github.com/gdamore/tcell"
+	"github.com/hexdiract/spear/core/audio"
+)
+
+//DeviceSwitcher is an audio device whose sound cards can be changed during a call
+type DeviceSwitcher interface {
+	Switch(input, output string) error
+	Selection() (input, output string)
+}
+
+type deviceList struct {
+	visible  bool
+	devices  []audio.DeviceInfo
+	selected int
+	message  string
+}
+
+func (layout *layout) toggleDeviceList() {
+	list := &layout.deviceList
+	list.visible = !list.visible
+	list.message = ""
+	if !list.visible {
+		return
+	}
+	devices, err := audio.ListDevices()
+	if err != nil {
+		list.message = "Unable to list devices: " + err.Error()
+	}
+	list.devices = devices
+	list.selected = 0
+}
+
+func (layout *layout) drawDeviceList(writer *writer) {
+	list := &layout.deviceList
+	input, output := layout.switcher.Selection()
+	writer.writeAt("  Up or Down arrow key to select device, I to capture from it, O to play to it, D to go back.")
+	writer.nextLine()
+	writer.nextLine()
+	writer.writeAt("  Capture: " + selectionName(input) + "    Playback: " + selectionName(output))
+	writer.nextLine()
+	writer.nextLine()
+	writer.x += 2
+	writer.writeAt("Index")
+	writer.x += 8
+	writer.writeAt("Device")
+	writer.x += 50
+	writer.writeAt("In")
+	writer.x += 5
+	writer.writeAt("Out")
+	writer.nextLine()
+	for i, device := range list.devices {
+		if i == list.selected {
+			writer.writeAt(">")
+		}
+		writer.x += 2
+		writer.writeAt(strconv.Itoa(device.Index))
+		writer.x += 8
+		writer.writeAt(device.Name)
+		writer.x += 50
+		writer.writeAt(strconv.Itoa(device.InputChannels))
+		writer.x += 5
+		writer.writeAt(strconv.Itoa(device.OutputChannels))
+		writer.nextLine()
+	}
+	writer.nextLine()
+	writer.writeAt("  " + list.message)
+}
+
+//handleDeviceKey handles a key while the device list is shown, it returns false for keys it ignores
+func (layout *layout) handleDeviceKey(event *tcell.EventKey) bool {
+	list := &layout.deviceList
+	switch event.Key() {
+	case tcell.KeyUp:
+		list.selected--
+	case tcell.KeyDown:
+		list.selected++
+	case tcell.KeyEscape:
+		layout.toggleDeviceList()
+		return true
+	case tcell.KeyRune:
+		input, output := layout.switcher.Selection()
+		switch event.Rune() {
+		case 'i', 'o':
+			if len(list.devices) == 0 {
+				return true
+			}
+			selector := strconv.Itoa(list.devices[list.selected].Index)
+			if event.Rune() == 'i' {
+				input = selector
+			} else {
+				output = selector
+			}
+			if err := layout.switcher.Switch(input, output); err != nil {
+				list.message = "Unable to switch device: " + err.Error()
+			} else {
+				list.message = "Switched to " + list.devices[list.selected].Name
+			}
+		case 'd':
+			layout.toggleDeviceList()
+		default:
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+
+	if m := len(list.devices); m > 0 {
+		list.selected = (list.selected + m) % m
+	}
+	return true
+}
+
+func selectionName(selector string) string {
+	if len(selector) == 0 {
+		return "default"
+	}
+	return selector
+}
@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hexdiract/spear/core/audio"
+)
+
+const (
+	//meterWidth is the number of cells inside a level meter
+	meterWidth = 10
+	//meterFloor is the level in dB shown as an empty meter
+	meterFloor = -60
+	//speakingHold keeps the speaking indicator on between words
+	speakingHold = time.Millisecond * 300
+)
+
+//meter renders a level as [####   | ] where # is the RMS and | the peak, on a dB scale
+func meter(level audio.Level) string {
+	cells := []byte(strings.Repeat(" ", meterWidth))
+	rmsCells := meterCells(level.RMS)
+	for i := 0; i < rmsCells; i++ {
+		cells[i] = '#'
+	}
+	if peakCells := meterCells(level.Peak); peakCells > rmsCells {
+		cells[peakCells-1] = '|'
+	}
+	return "[" + string(cells) + "]"
+}
+
+func meterCells(value float32) int {
+	if value <= 0 {
+		return 0
+	}
+	db := 20 * math.Log10(float64(value))
+	cells := int(math.Round((db - meterFloor) / -meterFloor * meterWidth))
+	if cells < 0 {
+		return 0
+	}
+	if cells > meterWidth {
+		return meterWidth
+	}
+	return cells
+}
+
+//speakingTracker smooths speaking indicators so they do not flicker between frames
+type speakingTracker map[interface{}]time.Time
+
+func (tracker speakingTracker) update(key interface{}, speaking bool) bool {
+	if speaking {
+		tracker[key] = time.Now()
+		return true
+	}
+	return time.Since(tracker[key]) < speakingHold
+}
@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/gdamore/tcell"
+	"github.com/hexdiract/spear/core/network"
+)
+
+//screenViewSamples is the number of samples taken across each side of the area of a frame shown as one pixel
+const screenViewSamples = 3
+
+//screenView shows the screen shared by a peer with half-block characters, each cell holding two pixels.
+//The view is toggled by the event goroutine, drawn by the tick one and fed by the peer's, mutex guards every field
+type screenView struct {
+	mutex   sync.Mutex
+	visible bool
+	peer    *network.Peer
+	frame   image.Image
+
+	//drawn is the frame pixels were computed from, at columns by rows pixels
+	drawn         image.Image
+	columns, rows int
+	width, height int
+	pixels        []tcell.Color
+}
+
+//isVisible tells whether the view is shown
+func (view *screenView) isVisible() bool {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	return view.visible
+}
+
+func (layout *layout) toggleScreenView() {
+	view := &layout.screenView
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	if view.visible {
+		view.peer.SetVideoCallback(nil)
+		view.visible = false
+		view.drawn, view.pixels = nil, nil
+		return
+	}
+
+	peer := layout.client.PeerList[layout.selectedPeerIndex]
+	view.peer, view.visible, view.frame = peer, true, nil
+	peer.SetVideoCallback(func(frame image.Image) {
+		view.mutex.Lock()
+		defer view.mutex.Unlock()
+		view.frame = frame
+	})
+}
+
+func (layout *layout) drawScreenView(screen *tcell.Screen) {
+	view := &layout.screenView
+	columns, lines := (*screen).Size()
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	//The view may have been closed since the tick checked it
+	if !view.visible {
+		return
+	}
+	frame := view.frame
+
+	writer := &writer{screen: screen, y: lines - 1}
+	status := "  " + view.peer.DisplayName() + ", V or Esc to go back."
+	if frame == nil {
+		writer.writeAt(status + " Waiting for the shared screen...")
+		return
+	}
+	writer.writeAt(status)
+
+	rows := (lines - 1) * 2
+	if frame != view.drawn || columns != view.columns || rows != view.rows {
+		view.scale(frame, columns, rows)
+	}
+	left := (columns - view.width) / 2
+	top := (rows - view.height) / 4
+	for y := 0; y < view.height; y += 2 {
+		for x := 0; x < view.width; x++ {
+			style := tcell.StyleDefault.Foreground(view.pixels[y*view.width+x])
+			if y+1 < view.height {
+				style = style.Background(view.pixels[(y+1)*view.width+x])
+			}
+			(*screen).SetContent(left+x, top+y/2, '▀', nil, style)
+		}
+	}
+}
+
+//scale computes the pixels of a frame fitted in columns by rows pixels, keeping its aspect ratio
+func (view *screenView) scale(frame image.Image, columns, rows int) {
+	bounds := frame.Bounds()
+	ratio := math.Min(float64(columns)/float64(bounds.Dx()), float64(rows)/float64(bounds.Dy()))
+	width := int(float64(bounds.Dx()) * ratio)
+	height := int(float64(bounds.Dy()) * ratio)
+	if len(view.pixels) < width*height {
+		view.pixels = make([]tcell.Color, width*height)
+	}
+
+	for y := 0; y < height; y++ {
+		y0, y1 := bounds.Min.Y+y*bounds.Dy()/height, bounds.Min.Y+(y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := bounds.Min.X+x*bounds.Dx()/width, bounds.Min.X+(x+1)*bounds.Dx()/width
+			view.pixels[y*width+x] = averageColor(frame, x0, y0, x1, y1)
+		}
+	}
+	view.drawn, view.columns, view.rows = frame, columns, rows
+	view.width, view.height = width, height
+}
+
+//averageColor averages a grid of samples of the area of a frame between (x0, y0) and (x1, y1)
+func averageColor(frame image.Image, x0, y0, x1, y1 int) tcell.Color {
+	var r, g, b, count uint32
+	for i := 0; i < screenViewSamples; i++ {
+		y := y0 + (y1-y0)*(2*i+1)/(2*screenViewSamples)
+		for j := 0; j < screenViewSamples; j++ {
+			x := x0 + (x1-x0)*(2*j+1)/(2*screenViewSamples)
+			sr, sg, sb, _ := frame.At(x, y).RGBA()
+			r, g, b, count = r+sr>>8, g+sg>>8, b+sb>>8, count+1
+		}
+	}
+	return tcell.NewRGBColor(int32(r/count), int32(g/count), int32(b/count))
+}
+
+//handleScreenViewKey handles a key while a shared screen is shown, it returns false for the keys
+//that still work there: quitting, the microphone, recording and sharing our own screen
+func (layout *layout) handleScreenViewKey(event *tcell.EventKey) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		layout.toggleScreenView()
+		return true
+	case tcell.KeyCtrlC:
+		return false
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case 'v':
+			layout.toggleScreenView()
+			return true
+		case 'q', 'm', 'p', ' ', 'r', 's', 'n', 'z':
+			return false
+		}
+	}
+	return true
+}
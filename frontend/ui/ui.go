@@ -2,33 +2,62 @@ package ui
 
 import (
 	"encoding/base64"
+	"image"
 	"math"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/hexdiract/spear/core/crypto"
 	"github.com/hexdiract/spear/core/network"
+	"github.com/hexdiract/spear/core/video"
 )
 
+//pushToTalkHold is how long the microphone stays open after a push-to-talk key event.
+//Terminals do not report key releases, so holding the key is detected through its auto-repeat
+const pushToTalkHold = time.Millisecond * 600
+
 type layout struct {
 	client            *network.Client
+	publicKey         string
 	selectedPeerIndex int
 	finish            bool
+
+	pushToTalk      bool
+	lastTalkPressed time.Time
+
+	switcher   DeviceSwitcher
+	deviceList deviceList
+	clipList   clipList
+	screenView screenView
+
+	speaking speakingTracker
+
+	//message reports the outcome of the last action
+	message string
 }
 
-//NewLayout creates a new CUI layout
-func NewLayout(client *network.Client) {
+//NewLayout creates a new CUI layout, clips are the paths of the sound files that can be played into the call
+func NewLayout(client *network.Client, switcher DeviceSwitcher, clips []string) error {
+	publicKey, err := crypto.CreatePublicKey(client.SecretKey)
+	if err != nil {
+		return err
+	}
 	layout := &layout{
 		client:            client,
+		publicKey:         base64.StdEncoding.EncodeToString(publicKey),
 		selectedPeerIndex: 0,
+		switcher:          switcher,
+		clipList:          clipList{clips: clips},
+		speaking:          speakingTracker{},
 	}
 	screen, err := tcell.NewScreen()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	if err := screen.Init(); err != nil {
-		panic(err)
+		return err
 	}
 	go layout.handleEvent(&screen)
 	for !layout.finish {
@@ -37,12 +66,21 @@ func NewLayout(client *network.Client) {
 		time.Sleep(time.Millisecond * 50)
 	}
 	screen.Fini()
+	return nil
 }
 
 func (layout *layout) tick(screen *tcell.Screen) {
+	if layout.pushToTalk {
+		layout.client.SetMuted(time.Since(layout.lastTalkPressed) > pushToTalkHold)
+	}
+
 	(*screen).Clear()
+	if layout.screenView.isVisible() {
+		layout.drawScreenView(screen)
+		return
+	}
 	writer := &writer{screen: screen}
-	writer.writeAt("  Current public key: " + base64.StdEncoding.EncodeToString(crypto.CreatePublicKey(layout.client.SecretKey)))
+	writer.writeAt("  Current public key: " + layout.publicKey)
 	writer.nextLine()
 	writer.writeAt("  Up or Down arrow key to select peer.")
 	writer.nextLine()
@@ -50,32 +88,230 @@ func (layout *layout) tick(screen *tcell.Screen) {
 	writer.nextLine()
 	writer.writeAt("  0 key to increase volume of peer.")
 	writer.nextLine()
+	writer.writeAt("  [ and ] keys to pan peer left or right in stereo.")
+	writer.nextLine()
+	writer.writeAt("  M to mute or unmute microphone.")
+	writer.nextLine()
+	writer.writeAt("  P to toggle push-to-talk, hold Space to talk.")
+	writer.nextLine()
+	writer.writeAt("  D to choose audio devices.")
+	writer.nextLine()
+	writer.writeAt("  R to start or stop recording the call.")
+	writer.nextLine()
+	writer.writeAt("  F to play a sound file into the call.")
+	writer.nextLine()
+	writer.writeAt("  S to start or stop sharing the screen.")
+	writer.nextLine()
+	writer.writeAt("  N to share the next display, Z to change the shared resolution.")
+	writer.nextLine()
+	writer.writeAt("  V to view the screen shared by peer.")
+	writer.nextLine()
+	writer.writeAt("  * marks who is speaking.")
+	writer.nextLine()
 	writer.writeAt("  Q to quit.")
 	writer.nextLine()
 	writer.nextLine()
+	level, transmitting := layout.client.LocalLevel()
+	if layout.speaking.update(layout.client, transmitting) {
+		writer.writeAt(" *")
+	}
+	writer.x += 2
+	writer.writeAt("Microphone: " + meter(level) + " " + layout.microphoneStatus())
+	writer.nextLine()
+	if len(layout.message) > 0 {
+		writer.writeAt("  " + layout.message)
+		writer.nextLine()
+	}
+	writer.nextLine()
+	if layout.deviceList.visible {
+		layout.drawDeviceList(writer)
+		return
+	}
+	if layout.clipList.visible {
+		layout.drawClipList(writer)
+		return
+	}
 	writer.x += 2
 	writer.writeAt("Peer")
 	writer.x += 50
 	writer.writeAt("Status")
 	writer.x += 15
+	writer.writeAt("Mic")
+	writer.x += 8
+	writer.writeAt("Rec")
+	writer.x += 5
+	writer.writeAt("Screen")
+	writer.x += 8
+	writer.writeAt("Level")
+	writer.x += 15
 	writer.writeAt("Volume")
 	writer.x += 10
+	writer.writeAt("Pan")
+	writer.x += 8
+	writer.writeAt("RTT")
+	writer.x += 10
+	writer.writeAt("Loss in")
+	writer.x += 10
+	writer.writeAt("Loss out")
+	writer.x += 10
+	writer.writeAt("Jitter")
+	writer.x += 10
+	writer.writeAt("Drift")
 	writer.nextLine()
 	for i, peer := range layout.client.PeerList {
 		if i == layout.selectedPeerIndex {
 			writer.writeAt(">")
 		}
+		level := layout.client.PeerLevel(peer)
+		if layout.speaking.update(peer, level.Speaking()) {
+			writer.x++
+			writer.writeAt("*")
+			writer.x--
+		}
 		writer.x += 2
 		writer.writeAt(peer.DisplayName())
 		writer.x += 50
 		writer.writeAt(peer.Status())
 		writer.x += 15
+		if peer.Muted() {
+			writer.writeAt("muted")
+		} else {
+			writer.writeAt("on")
+		}
+		writer.x += 8
+		if peer.Recording() {
+			writer.writeAt("REC")
+		}
+		writer.x += 5
+		if peer.SharingScreen() {
+			writer.writeAt("shared")
+		}
+		writer.x += 8
+		writer.writeAt(meter(level))
+		writer.x += 15
 		vol := strconv.Itoa(int(math.Round(float64(peer.Volume*10)))*10) + "%"
 		writer.writeAt(vol)
+		writer.x += 10
+		writer.writeAt(formatPan(peer.Pan))
+		writer.x += 8
+		stats := peer.Statistics()
+		writer.writeAt(strconv.Itoa(int(stats.RTT/time.Millisecond)) + "ms")
+		writer.x += 10
+		writer.writeAt(formatPercentage(stats.Local.Loss))
+		writer.x += 10
+		writer.writeAt(formatPercentage(stats.Remote.Loss))
+		writer.x += 10
+		writer.writeAt(strconv.Itoa(int(stats.Local.Jitter/time.Millisecond)) + "ms")
+		writer.x += 10
+		writer.writeAt(strconv.FormatFloat((peer.PlaybackSpeed()-1)*100, 'f', 2, 64) + "%")
 		writer.nextLine()
 	}
 }
 
+func (layout *layout) microphoneStatus() string {
+	status := "live"
+	if layout.client.Muted() {
+		status = "muted"
+	}
+	if layout.pushToTalk {
+		status += " (push-to-talk)"
+	}
+	if layout.client.Injector.Playing() {
+		status += ", playing " + filepath.Base(layout.clipList.playing)
+	}
+	if layout.client.Recording() {
+		status += ", recording"
+	}
+	if layout.client.Screencasting() {
+		status += ", sharing screen"
+	}
+	return status
+}
+
+func (layout *layout) toggleRecording() {
+	if layout.client.Recording() {
+		if err := layout.client.StopRecording(); err != nil {
+			layout.message = "Unable to finish recording: " + err.Error()
+		} else {
+			layout.message = "Recording saved to " + layout.client.RecordingOptions.Directory
+		}
+		return
+	}
+	if err := layout.client.StartRecording(); err != nil {
+		layout.message = "Unable to record: " + err.Error()
+	} else {
+		layout.message = "Recording to " + layout.client.RecordingOptions.Directory
+	}
+}
+
+func (layout *layout) toggleScreencast() {
+	if layout.client.Screencasting() {
+		layout.client.StopScreencast()
+		layout.message = "Stopped sharing the screen"
+		return
+	}
+	if err := layout.client.StartScreencast(); err != nil {
+		layout.message = "Unable to share the screen: " + err.Error()
+	} else {
+		layout.message = "Sharing the screen"
+	}
+}
+
+//screencastResolutions are the maximum resolutions Z cycles through, the zero value being the native one
+var screencastResolutions = []video.Resolution{{Width: 1280, Height: 720}, {Width: 1920, Height: 1080}, {}}
+
+func (layout *layout) nextDisplay() {
+	displays := video.Displays()
+	if len(displays) == 0 {
+		layout.message = "No display to share"
+		return
+	}
+	options := layout.client.CaptureOptions()
+	options.Display = (options.Display + 1) % len(displays)
+	options.Region = image.Rectangle{}
+	if err := layout.client.SetCaptureOptions(options); err != nil {
+		layout.message = "Unable to change the display: " + err.Error()
+		return
+	}
+	bounds := displays[options.Display]
+	layout.message = "Sharing display " + strconv.Itoa(options.Display) + " (" + strconv.Itoa(bounds.Dx()) + "x" + strconv.Itoa(bounds.Dy()) + ")"
+}
+
+func (layout *layout) nextResolution() {
+	options := layout.client.CaptureOptions()
+	next := 0
+	for i, resolution := range screencastResolutions {
+		if resolution == options.MaxResolution {
+			next = (i + 1) % len(screencastResolutions)
+		}
+	}
+	options.MaxResolution = screencastResolutions[next]
+	if err := layout.client.SetCaptureOptions(options); err != nil {
+		layout.message = "Unable to change the resolution: " + err.Error()
+		return
+	}
+	if options.MaxResolution == (video.Resolution{}) {
+		layout.message = "Sharing the screen at its native resolution"
+	} else {
+		layout.message = "Sharing the screen at up to " + strconv.Itoa(options.MaxResolution.Width) + "x" + strconv.Itoa(options.MaxResolution.Height)
+	}
+}
+
+func formatPercentage(fraction float32) string {
+	return strconv.Itoa(int(math.Round(float64(fraction*100)))) + "%"
+}
+
+func formatPan(pan float32) string {
+	percentage := int(math.Round(float64(pan * 100)))
+	switch {
+	case percentage < 0:
+		return "L" + strconv.Itoa(-percentage)
+	case percentage > 0:
+		return "R" + strconv.Itoa(percentage)
+	}
+	return "C"
+}
+
 func (layout *layout) handleEvent(screen *tcell.Screen) {
 	for event := (*screen).PollEvent(); event != nil; event = (*screen).PollEvent() {
 		if keyEvent, ok := event.(*tcell.EventKey); ok {
@@ -85,9 +321,51 @@ func (layout *layout) handleEvent(screen *tcell.Screen) {
 }
 
 func (layout *layout) handleKey(screen *tcell.Screen, event *tcell.EventKey) {
+	if layout.deviceList.visible && layout.handleDeviceKey(event) {
+		return
+	}
+	if layout.clipList.visible && layout.handleClipKey(event) {
+		return
+	}
+	if layout.screenView.isVisible() && layout.handleScreenViewKey(event) {
+		return
+	}
+
 	switch event.Rune() {
 	case 'q':
 		layout.finish = true
+	case 'm':
+		layout.pushToTalk = false
+		layout.client.SetMuted(!layout.client.Muted())
+	case 'p':
+		layout.pushToTalk = !layout.pushToTalk
+		layout.client.SetMuted(layout.pushToTalk)
+	case ' ':
+		layout.lastTalkPressed = time.Now()
+	case 'd':
+		layout.toggleDeviceList()
+	case 'f':
+		layout.toggleClipList()
+	case 'r':
+		layout.toggleRecording()
+	case 's':
+		layout.toggleScreencast()
+	case 'n':
+		layout.nextDisplay()
+	case 'z':
+		layout.nextResolution()
+	}
+	if event.Key() == tcell.KeyCtrlC {
+		layout.finish = true
+	}
+
+	//The remaining keys act on the selected peer
+	if len(layout.client.PeerList) == 0 {
+		return
+	}
+	switch event.Rune() {
+	case 'v':
+		layout.toggleScreenView()
 	case '9':
 		peer := layout.client.PeerList[layout.selectedPeerIndex]
 		if peer.Volume > 0 {
@@ -98,10 +376,18 @@ func (layout *layout) handleKey(screen *tcell.Screen, event *tcell.EventKey) {
 		if peer.Volume < 2 {
 			peer.Volume += 0.1
 		}
+	case '[':
+		peer := layout.client.PeerList[layout.selectedPeerIndex]
+		if peer.Pan > -1 {
+			peer.Pan -= 0.25
+		}
+	case ']':
+		peer := layout.client.PeerList[layout.selectedPeerIndex]
+		if peer.Pan < 1 {
+			peer.Pan += 0.25
+		}
 	}
 	switch event.Key() {
-	case tcell.KeyCtrlC:
-		layout.finish = true
 	case tcell.KeyUp:
 		layout.selectedPeerIndex++
 	case tcell.KeyDown: